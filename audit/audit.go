@@ -0,0 +1,444 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/config"
+	"github.com/greatfocus/gf-sframe/database"
+)
+
+// redacted replaces the values of redacted fields
+const redacted = "REDACTED"
+
+// verifyBatch is the number of entries read at once by Verify
+const verifyBatch = 1000
+
+// ErrTampered is returned by Verify when the hash chain is broken
+var ErrTampered = errors.New("audit trail has been tampered with")
+
+// Entry is a record of the audit trail
+type Entry struct {
+	ID         int64                  `json:"id"`
+	ActorID    int64                  `json:"actorId"`
+	ActorRole  string                 `json:"actorRole,omitempty"`
+	Tenant     string                 `json:"tenant,omitempty"`
+	Action     string                 `json:"action"`
+	Resource   string                 `json:"resource"`
+	ResourceID string                 `json:"resourceId,omitempty"`
+	Before     json.RawMessage        `json:"before,omitempty"`
+	After      json.RawMessage        `json:"after,omitempty"`
+	Diff       json.RawMessage        `json:"diff,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	RequestID  string                 `json:"requestId,omitempty"`
+	IP         string                 `json:"ip,omitempty"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	Chain      string                 `json:"chain,omitempty"`
+	PrevHash   string                 `json:"prevHash"`
+	Hash       string                 `json:"hash"`
+}
+
+// record is an audit row
+type record struct {
+	ID         int64     `db:"id"`
+	ActorID    int64     `db:"actor_id"`
+	ActorRole  string    `db:"actor_role"`
+	Tenant     string    `db:"tenant"`
+	Action     string    `db:"action"`
+	Resource   string    `db:"resource"`
+	ResourceID string    `db:"resource_id"`
+	Before     []byte    `db:"before"`
+	After      []byte    `db:"after"`
+	Diff       []byte    `db:"diff"`
+	Metadata   []byte    `db:"metadata"`
+	RequestID  string    `db:"request_id"`
+	IP         string    `db:"ip"`
+	CreatedAt  time.Time `db:"created_at"`
+	Chain      string    `db:"chain"`
+	PrevHash   string    `db:"prev_hash"`
+	Hash       string    `db:"hash"`
+}
+
+// entry converts the row to an Entry
+func (r record) entry() Entry {
+	e := Entry{
+		ID:         r.ID,
+		ActorID:    r.ActorID,
+		ActorRole:  r.ActorRole,
+		Tenant:     r.Tenant,
+		Action:     r.Action,
+		Resource:   r.Resource,
+		ResourceID: r.ResourceID,
+		Before:     r.Before,
+		After:      r.After,
+		Diff:       r.Diff,
+		RequestID:  r.RequestID,
+		IP:         r.IP,
+		CreatedAt:  r.CreatedAt,
+		Chain:      r.Chain,
+		PrevHash:   r.PrevHash,
+		Hash:       r.Hash,
+	}
+	if len(r.Metadata) > 0 {
+		_ = json.Unmarshal(r.Metadata, &e.Metadata)
+	}
+	return e
+}
+
+// chained holds the fields covered by the hash in a fixed order
+type chained struct {
+	ActorID    int64           `json:"actorId"`
+	ActorRole  string          `json:"actorRole"`
+	Tenant     string          `json:"tenant"`
+	Action     string          `json:"action"`
+	Resource   string          `json:"resource"`
+	ResourceID string          `json:"resourceId"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	Diff       json.RawMessage `json:"diff"`
+	Metadata   json.RawMessage `json:"metadata"`
+	RequestID  string          `json:"requestId"`
+	IP         string          `json:"ip"`
+	CreatedAt  string          `json:"createdAt"`
+	// Chain is omitted for the entries of the single chain of old trails
+	Chain string `json:"chain,omitempty"`
+}
+
+// hash returns the hash of the row chained to the previous one
+func (r record) hash() (string, error) {
+	body, err := json.Marshal(chained{
+		ActorID:    r.ActorID,
+		ActorRole:  r.ActorRole,
+		Tenant:     r.Tenant,
+		Action:     r.Action,
+		Resource:   r.Resource,
+		ResourceID: r.ResourceID,
+		Before:     rawOrNull(r.Before),
+		After:      rawOrNull(r.After),
+		Diff:       rawOrNull(r.Diff),
+		Metadata:   rawOrNull(r.Metadata),
+		RequestID:  r.RequestID,
+		IP:         r.IP,
+		CreatedAt:  r.CreatedAt.UTC().Format(time.RFC3339Nano),
+		Chain:      r.Chain,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(r.PrevHash+"\n"), body...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Audit records who changed what in an append-only table. Every entry
+// carries the hash of the previous entry of its chain, so that editing or
+// removing an entry out of band breaks the chain checked by Verify. The
+// entries of a record form a chain, so that appends to different records
+// do not wait for each other.
+type Audit struct {
+	conn   *database.Conn
+	table  string
+	redact map[string]bool
+}
+
+// New creates the audit trail and migrates its table
+func New(ctx context.Context, conn *database.Conn, cfg config.Audit) (*Audit, error) {
+	if conn.Dialect() != database.Postgres {
+		return nil, fmt.Errorf("audit on %s: %w", conn.Dialect().Name(), database.ErrUnsupported)
+	}
+	a := &Audit{
+		conn:   conn,
+		table:  cfg.Table,
+		redact: make(map[string]bool),
+	}
+	for _, field := range cfg.RedactFields {
+		a.redact[strings.ToLower(field)] = true
+	}
+
+	// the versions of the audit trail are tracked apart from the service's
+	migrator, err := database.NewMigrator(conn, Migrations(a.table))
+	if err != nil {
+		return nil, err
+	}
+	migrator.Table = a.table + "_migrations"
+	if _, err := migrator.Up(ctx); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Migrations returns the migrations of the audit table, its indexes and the
+// triggers rejecting updates, deletes and truncates
+func Migrations(table string) []database.Migration {
+	quoted := database.QuoteIdentifier(table)
+	name := table[strings.LastIndex(table, ".")+1:]
+	function := database.QuoteIdentifier(table + "_append_only")
+	return []database.Migration{
+		{
+			Version: 1,
+			Name:    "create_audit",
+			Up: fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %[1]s (
+				id BIGSERIAL PRIMARY KEY,
+				actor_id BIGINT NOT NULL DEFAULT 0,
+				actor_role TEXT NOT NULL DEFAULT '',
+				tenant TEXT NOT NULL DEFAULT '',
+				action TEXT NOT NULL,
+				resource TEXT NOT NULL,
+				resource_id TEXT NOT NULL DEFAULT '',
+				before JSON,
+				after JSON,
+				diff JSON,
+				metadata JSON,
+				request_id TEXT NOT NULL DEFAULT '',
+				ip TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL,
+				prev_hash TEXT NOT NULL,
+				hash TEXT NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (resource, resource_id);
+			CREATE INDEX IF NOT EXISTS %[3]s ON %[1]s (actor_id, created_at);
+			CREATE OR REPLACE FUNCTION %[4]s() RETURNS trigger LANGUAGE plpgsql AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit trail is append-only';
+			END $$;
+			CREATE OR REPLACE TRIGGER %[5]s BEFORE UPDATE OR DELETE ON %[1]s
+				FOR EACH ROW EXECUTE FUNCTION %[4]s();
+			CREATE OR REPLACE TRIGGER %[6]s BEFORE TRUNCATE ON %[1]s
+				FOR EACH STATEMENT EXECUTE FUNCTION %[4]s()`,
+				quoted,
+				database.QuoteIdentifier(name+"_resource"),
+				database.QuoteIdentifier(name+"_actor"),
+				function,
+				database.QuoteIdentifier(name+"_append_only"),
+				database.QuoteIdentifier(name+"_no_truncate")),
+			Down: fmt.Sprintf("DROP TABLE %s; DROP FUNCTION %s()", quoted, function),
+		},
+		{
+			// the entries written before form the chain named ''
+			Version: 2,
+			Name:    "add_audit_chain",
+			Up: fmt.Sprintf(`
+				ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS chain TEXT NOT NULL DEFAULT '';
+				CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (chain, id)`,
+				quoted, database.QuoteIdentifier(name+"_chain")),
+			Down: "ALTER TABLE " + quoted + " DROP COLUMN chain",
+		},
+	}
+}
+
+// Record appends e to the trail. It joins the transaction of ctx if any, so
+// the entry commits or rolls back with the change it describes. Empty actor,
+// request, IP and tenant fields are taken from ctx.
+func (a *Audit) Record(ctx context.Context, e Entry) error {
+	if e.Action == "" || e.Resource == "" {
+		return errors.New("audit entry without action or resource")
+	}
+	if req, ok := RequestFromContext(ctx); ok {
+		if e.ActorID == 0 {
+			e.ActorID, e.ActorRole = req.ActorID, req.ActorRole
+		}
+		if e.RequestID == "" {
+			e.RequestID = req.RequestID
+		}
+		if e.IP == "" {
+			e.IP = req.IP
+		}
+	}
+	if tenant, ok := database.TenantFromContext(ctx); ok && e.Tenant == "" {
+		e.Tenant = tenant
+	}
+
+	r := record{
+		ActorID:    e.ActorID,
+		ActorRole:  e.ActorRole,
+		Tenant:     e.Tenant,
+		Action:     e.Action,
+		Resource:   e.Resource,
+		ResourceID: e.ResourceID,
+		Before:     e.Before,
+		After:      e.After,
+		Diff:       e.Diff,
+		RequestID:  e.RequestID,
+		IP:         e.IP,
+		// Postgres keeps microseconds, the hash must survive a round trip
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if len(e.Metadata) > 0 {
+		metadata, err := json.Marshal(e.Metadata)
+		if err != nil {
+			return err
+		}
+		r.Metadata = metadata
+	}
+
+	r.Chain = chainOf(r)
+	return a.conn.WithTx(ctx, nil, func(tx database.Tx) error {
+		// appends to a chain are serialized so that each entry chains to
+		// the last one, the lock is held until the business data commits
+		if err := database.XactLock(ctx, tx, "audit:"+a.table+":"+r.Chain); err != nil {
+			return err
+		}
+		prev, err := database.QueryScalar[string](ctx, tx, fmt.Sprintf(
+			"SELECT hash FROM %s WHERE chain = $1 ORDER BY id DESC LIMIT 1", database.QuoteIdentifier(a.table)), r.Chain)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		r.PrevHash = prev
+		if r.Hash, err = r.hash(); err != nil {
+			return err
+		}
+		_, err = database.Insert(a.table).
+			Columns("actor_id", "actor_role", "tenant", "action", "resource", "resource_id",
+				"before", "after", "diff", "metadata", "request_id", "ip", "created_at", "chain", "prev_hash", "hash").
+			Values(r.ActorID, r.ActorRole, r.Tenant, r.Action, r.Resource, r.ResourceID,
+				nullJSON(r.Before), nullJSON(r.After), nullJSON(r.Diff), nullJSON(r.Metadata),
+				r.RequestID, r.IP, r.CreatedAt, r.Chain, r.PrevHash, r.Hash).
+			Exec(ctx, tx)
+		return err
+	})
+}
+
+// chainOf returns the chain of the record, named after its tenant, resource
+// and resource id
+func chainOf(r record) string {
+	chain := r.Resource + "/" + r.ResourceID
+	if r.Tenant != "" {
+		chain = r.Tenant + "/" + chain
+	}
+	return chain
+}
+
+// Hook returns a repository change hook recording every write with its
+// before and after state
+func (a *Audit) Hook() database.ChangeHook {
+	return func(ctx context.Context, change database.Change) error {
+		before, err := a.snapshot(change.Before)
+		if err != nil {
+			return err
+		}
+		after, err := a.snapshot(change.After)
+		if err != nil {
+			return err
+		}
+		diff, err := Diff(before, after)
+		if err != nil {
+			return err
+		}
+		return a.Record(ctx, Entry{
+			Action:     change.Action,
+			Resource:   change.Table,
+			ResourceID: fmt.Sprint(change.ID),
+			Before:     before,
+			After:      after,
+			Diff:       diff,
+		})
+	}
+}
+
+// snapshot marshals v with the redacted fields masked
+func (a *Audit) snapshot(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	body, err := json.Marshal(v)
+	if err != nil || len(a.redact) == 0 {
+		return body, err
+	}
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) != nil {
+		return body, nil
+	}
+	for key := range fields {
+		if a.redact[strings.ToLower(key)] {
+			fields[key] = redacted
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// Diff returns the top level fields of two JSON objects that differ, with
+// their before and after values
+func Diff(before, after json.RawMessage) (json.RawMessage, error) {
+	if before == nil || after == nil {
+		return nil, nil
+	}
+	var b, c map[string]interface{}
+	if err := json.Unmarshal(before, &b); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(after, &c); err != nil {
+		return nil, err
+	}
+
+	type change struct {
+		Before interface{} `json:"before"`
+		After  interface{} `json:"after"`
+	}
+	diff := make(map[string]change)
+	for key, value := range b {
+		if !equal(value, c[key]) {
+			diff[key] = change{Before: value, After: c[key]}
+		}
+	}
+	for key, value := range c {
+		if _, ok := b[key]; !ok {
+			diff[key] = change{After: value}
+		}
+	}
+	if len(diff) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(diff)
+}
+
+// equal compares two decoded JSON values
+func equal(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+// rawOrNull returns raw, or the JSON null for an empty value
+func rawOrNull(raw []byte) json.RawMessage {
+	if len(raw) == 0 {
+		return json.RawMessage("null")
+	}
+	return raw
+}
+
+// nullJSON returns raw as a query argument, NULL when empty
+func nullJSON(raw []byte) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+// Request describes the caller of the request being audited
+type Request struct {
+	ActorID   int64
+	ActorRole string
+	RequestID string
+	IP        string
+}
+
+// requestKey is the context key of the request
+type requestKey struct{}
+
+// WithRequest returns a context whose entries are attributed to req
+func WithRequest(ctx context.Context, req Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// RequestFromContext returns the request of ctx
+func RequestFromContext(ctx context.Context) (Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(Request)
+	return req, ok
+}
@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/database"
+)
+
+// defaultLimit and maxLimit bound the entries returned by Query
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// columns of an audit row
+var columns = []string{"id", "actor_id", "actor_role", "tenant", "action", "resource", "resource_id",
+	"before", "after", "diff", "metadata", "request_id", "ip", "created_at", "chain", "prev_hash", "hash"}
+
+// Filter selects audit entries, zero fields match any entry
+type Filter struct {
+	ActorID    int64
+	Action     string
+	Resource   string
+	ResourceID string
+	Tenant     string
+	RequestID  string
+	From       time.Time
+	To         time.Time
+	// AfterID returns the entries following the last one of a previous page
+	AfterID int64
+	Limit   int
+}
+
+// ParseFilter reads a filter from query parameters, with times in RFC 3339
+func ParseFilter(values url.Values) (Filter, error) {
+	f := Filter{
+		Action:     values.Get("action"),
+		Resource:   values.Get("resource"),
+		ResourceID: values.Get("resourceId"),
+		Tenant:     values.Get("tenant"),
+		RequestID:  values.Get("requestId"),
+	}
+	var err error
+	if v := values.Get("actorId"); v != "" {
+		if f.ActorID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return f, fmt.Errorf("invalid actorId %q", v)
+		}
+	}
+	if v := values.Get("afterId"); v != "" {
+		if f.AfterID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return f, fmt.Errorf("invalid afterId %q", v)
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil {
+			return f, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	if v := values.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("invalid from %q", v)
+		}
+	}
+	if v := values.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("invalid to %q", v)
+		}
+	}
+	return f, nil
+}
+
+// Query returns the entries matching f in order, a page at a time
+func (a *Audit) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	query, args, err := database.Select(columns...).
+		From(a.table).
+		WhereIf(f.ActorID != 0, "actor_id = ?", f.ActorID).
+		WhereIf(f.Action != "", "action = ?", f.Action).
+		WhereIf(f.Resource != "", "resource = ?", f.Resource).
+		WhereIf(f.ResourceID != "", "resource_id = ?", f.ResourceID).
+		WhereIf(f.Tenant != "", "tenant = ?", f.Tenant).
+		WhereIf(f.RequestID != "", "request_id = ?", f.RequestID).
+		WhereIf(!f.From.IsZero(), "created_at >= ?", f.From).
+		WhereIf(!f.To.IsZero(), "created_at < ?", f.To).
+		WhereIf(f.AfterID != 0, "id > ?", f.AfterID).
+		OrderBy("id", false).
+		Limit(limit).
+		BuildFor(a.conn.Dialect())
+	if err != nil {
+		return nil, err
+	}
+	records, err := database.QueryStructs[record](ctx, a.conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(records))
+	for i, r := range records {
+		entries[i] = r.entry()
+	}
+	return entries, nil
+}
+
+// Verify recomputes each hash chain from its first entry and returns the
+// number of entries checked. It fails with ErrTampered at the first entry
+// that was changed, or whose predecessor was removed. Chains have no
+// anchor, so removing the newest entries of a chain, or a whole chain, goes
+// unnoticed: the triggers rejecting deletes are the guard against it, and
+// the hashes of Query results can be kept elsewhere to check against.
+func (a *Audit) Verify(ctx context.Context) (int64, error) {
+	// replicas may lag behind the last appended entries
+	ctx = database.WithPrimary(ctx)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE (chain, id) > ($1, $2) ORDER BY chain, id LIMIT $3",
+		joinColumns(), database.QuoteIdentifier(a.table))
+
+	var checked, last int64
+	var chain, prev string
+	for {
+		records, err := database.QueryStructs[record](ctx, a.conn, query, chain, last, verifyBatch)
+		if err != nil {
+			return checked, err
+		}
+		for _, r := range records {
+			if r.Chain != chain {
+				// the first entry of a chain follows no other
+				chain, prev = r.Chain, ""
+			}
+			if r.PrevHash != prev {
+				return checked, fmt.Errorf("%w: entry %d does not follow the previous one", ErrTampered, r.ID)
+			}
+			hash, err := r.hash()
+			if err != nil {
+				return checked, err
+			}
+			if hash != r.Hash {
+				return checked, fmt.Errorf("%w: entry %d was modified", ErrTampered, r.ID)
+			}
+			prev, last = r.Hash, r.ID
+			checked++
+		}
+		if len(records) < verifyBatch {
+			return checked, nil
+		}
+	}
+}
+
+// joinColumns returns the quoted columns of an audit row
+func joinColumns() string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = database.QuoteIdentifier(column)
+	}
+	return strings.Join(quoted, ", ")
+}
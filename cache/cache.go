@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gfcache "github.com/greatfocus/gf-cache"
+)
+
+// Cache struct keeps items in a Store, an unbounded memory store unless
+// SetStore picks a shared one, and counts hits and misses. Items are
+// encoded to JSON. Get returns the values set by this instance as they were
+// set while they are held in memory, and the values read from a shared
+// store decoded from JSON. Add, Replace and the increments are atomic
+// within an instance only.
+type Cache struct {
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	expiration time.Duration
+	negative   time.Duration
+	jitter     float64
+	flight     group
+	// mu serializes the methods reading an item before writing it
+	mu     sync.Mutex
+	store  Store
+	logger *slog.Logger
+}
+
+// New creates a cache with the default expiration, expired items are
+// removed on access and by the purge of the store
+func New(defaultExpiration time.Duration) *Cache {
+	store, _ := NewMemory(0, EvictLRU)
+	return &Cache{
+		expiration: defaultExpiration,
+		store:      store,
+		logger:     slog.Default(),
+	}
+}
+
+// SetJitter shortens the expiration of loaded items by a random fraction
+// of up to jitter, so that items loaded together do not expire together
+func (c *Cache) SetJitter(jitter float64) {
+	c.jitter = jitter
+}
+
+// SetNegativeExpiration sets how long GetOrLoad remembers that an item was
+// not found, zero disables negative caching
+func (c *Cache) SetNegativeExpiration(d time.Duration) {
+	c.negative = d
+}
+
+// SetStore replaces the store of encoded values
+func (c *Cache) SetStore(store Store) {
+	c.store = store
+}
+
+// Store returns the store of encoded values
+func (c *Cache) Store() Store {
+	return c.store
+}
+
+// SetLogger sets the logger of store failures, which are treated as misses
+func (c *Cache) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// Set adds an item to the store, replacing any existing item. A zero d is
+// the default expiration and a negative one never expires.
+func (c *Cache) Set(k string, x interface{}, d time.Duration) {
+	c.SetWithTags(k, x, d)
+}
+
+// SetWithTags adds an item to the store, replacing any existing item, and
+// tags it for Invalidate
+func (c *Cache) SetWithTags(k string, x interface{}, d time.Duration, tags ...string) {
+	if err := c.put(k, x, c.item(nil, d, tags)); err != nil {
+		c.logger.Warn("cache store failed", "key", k, "error", err)
+	}
+}
+
+// SetDefault adds an item to the store with the default expiration
+func (c *Cache) SetDefault(k string, x interface{}) {
+	c.Set(k, x, 0)
+}
+
+// Add adds an item unless one exists for k
+func (c *Cache) Add(k string, x interface{}, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, _, found := c.object(k); found {
+		return fmt.Errorf("item %s already exists", k)
+	}
+	return c.put(k, x, c.item(nil, d, nil))
+}
+
+// Replace sets an item only if one exists for k
+func (c *Cache) Replace(k string, x interface{}, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, _, found := c.object(k); !found {
+		return fmt.Errorf("item %s doesn't exist", k)
+	}
+	return c.put(k, x, c.item(nil, d, nil))
+}
+
+// Get an item from the store and record the lookup
+func (c *Cache) Get(k string) (interface{}, bool) {
+	x, _, found := c.GetWithExpiration(k)
+	return x, found
+}
+
+// GetWithExpiration an item and its expiration from the store and record
+// the lookup, the expiration is zero for items that never expire
+func (c *Cache) GetWithExpiration(k string) (interface{}, time.Time, bool) {
+	x, item, found := c.object(k)
+	c.record(found)
+	return x, item.Expiration, found
+}
+
+// Delete removes an item from the store
+func (c *Cache) Delete(k string) {
+	if err := c.store.Delete(context.Background(), k); err != nil {
+		c.logger.Warn("cache delete failed", "key", k, "error", err)
+	}
+}
+
+// DeleteExpired removes the expired items of stores that keep them
+func (c *Cache) DeleteExpired() {
+	if p, ok := c.store.(Purger); ok {
+		if err := p.Purge(context.Background()); err != nil {
+			c.logger.Warn("cache purge failed", "error", err)
+		}
+	}
+}
+
+// Items returns the unexpired items of stores that can list them
+func (c *Cache) Items() map[string]gfcache.Item {
+	items := make(map[string]gfcache.Item)
+	l, ok := c.store.(Lister)
+	if !ok {
+		return items
+	}
+	listed, err := l.Items(context.Background())
+	if err != nil {
+		c.logger.Warn("cache listing failed", "error", err)
+	}
+	for k, item := range listed {
+		if x, ok := decode(item); ok {
+			var expiration int64
+			if !item.Expiration.IsZero() {
+				expiration = item.Expiration.UnixNano()
+			}
+			items[k] = gfcache.Item{Object: x, Expiration: expiration}
+		}
+	}
+	return items
+}
+
+// ItemCount returns the number of unexpired items
+func (c *Cache) ItemCount() int {
+	return len(c.Items())
+}
+
+// Evict deletes the encoded values of keys
+func (c *Cache) Evict(ctx context.Context, keys ...string) error {
+	return c.store.Delete(ctx, keys...)
+}
+
+// Invalidate deletes the items carrying any of the tags, failures are
+// logged and Store().Invalidate returns them
+func (c *Cache) Invalidate(tags ...string) {
+	if err := c.store.Invalidate(context.Background(), tags...); err != nil {
+		c.logger.Warn("cache invalidate failed", "tags", tags, "error", err)
+	}
+}
+
+// Flush deletes all the items of the store
+func (c *Cache) Flush() {
+	if err := c.store.Flush(context.Background()); err != nil {
+		c.logger.Warn("cache flush failed", "error", err)
+	}
+}
+
+// Hits returns the number of successful lookups
+func (c *Cache) Hits() uint64 {
+	return c.hits.Load()
+}
+
+// Misses returns the number of failed lookups
+func (c *Cache) Misses() uint64 {
+	return c.misses.Load()
+}
+
+// object returns the value of the item k, store failures are misses
+func (c *Cache) object(k string) (interface{}, Item, bool) {
+	item, found, err := c.store.Get(context.Background(), k)
+	if err != nil {
+		c.logger.Warn("cache lookup failed", "key", k, "error", err)
+	}
+	if !found {
+		return nil, Item{}, false
+	}
+	x, ok := decode(item)
+	return x, item, ok
+}
+
+// put stores x as the value of item
+func (c *Cache) put(k string, x interface{}, item Item) error {
+	body, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	item.Value = append([]byte{kindValue}, body...)
+	item.object = x
+	return c.store.Set(context.Background(), k, item)
+}
+
+// decode returns the value of an item, the failed loads of GetOrLoad are
+// not values
+func decode(item Item) (interface{}, bool) {
+	if len(item.Value) == 0 || item.Value[0] != kindValue {
+		return nil, false
+	}
+	if item.object != nil {
+		return item.object, true
+	}
+	var x interface{}
+	if err := json.Unmarshal(item.Value[1:], &x); err != nil {
+		return nil, false
+	}
+	return x, true
+}
+
+// record counts a lookup as hit or miss
+func (c *Cache) record(found bool) {
+	if found {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
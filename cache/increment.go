@@ -0,0 +1,252 @@
+package cache
+
+import "fmt"
+
+// number is the type of the values of the typed increments
+type number interface {
+	int | int8 | int16 | int32 | int64 | uint | uintptr | uint8 | uint16 | uint32 | uint64 | float32 | float64
+}
+
+// Increment adds n to an item of any integer or floating point type
+func (c *Cache) Increment(k string, n int64) error {
+	return c.update(k, func(x interface{}) (interface{}, bool) { return addInt(x, n) },
+		"the value for %s is not an integer")
+}
+
+// IncrementFloat adds n to an item of type float32 or float64
+func (c *Cache) IncrementFloat(k string, n float64) error {
+	return c.update(k, func(x interface{}) (interface{}, bool) { return addFloat(x, n) },
+		"the value for %s does not have type float32 or float64")
+}
+
+// Decrement subtracts n from an item of any integer or floating point type
+func (c *Cache) Decrement(k string, n int64) error {
+	return c.update(k, func(x interface{}) (interface{}, bool) { return addInt(x, -n) },
+		"the value for %s is not an integer")
+}
+
+// DecrementFloat subtracts n from an item of type float32 or float64
+func (c *Cache) DecrementFloat(k string, n float64) error {
+	return c.update(k, func(x interface{}) (interface{}, bool) { return addFloat(x, -n) },
+		"the value for %s does not have type float32 or float64")
+}
+
+// IncrementInt adds n to an item of type int and returns the result
+func (c *Cache) IncrementInt(k string, n int) (int, error) {
+	return add(c, k, n)
+}
+
+// IncrementInt8 adds n to an item of type int8 and returns the result
+func (c *Cache) IncrementInt8(k string, n int8) (int8, error) {
+	return add(c, k, n)
+}
+
+// IncrementInt16 adds n to an item of type int16 and returns the result
+func (c *Cache) IncrementInt16(k string, n int16) (int16, error) {
+	return add(c, k, n)
+}
+
+// IncrementInt32 adds n to an item of type int32 and returns the result
+func (c *Cache) IncrementInt32(k string, n int32) (int32, error) {
+	return add(c, k, n)
+}
+
+// IncrementInt64 adds n to an item of type int64 and returns the result
+func (c *Cache) IncrementInt64(k string, n int64) (int64, error) {
+	return add(c, k, n)
+}
+
+// IncrementUint adds n to an item of type uint and returns the result
+func (c *Cache) IncrementUint(k string, n uint) (uint, error) {
+	return add(c, k, n)
+}
+
+// IncrementUintptr adds n to an item of type uintptr and returns the result
+func (c *Cache) IncrementUintptr(k string, n uintptr) (uintptr, error) {
+	return add(c, k, n)
+}
+
+// IncrementUint8 adds n to an item of type uint8 and returns the result
+func (c *Cache) IncrementUint8(k string, n uint8) (uint8, error) {
+	return add(c, k, n)
+}
+
+// IncrementUint16 adds n to an item of type uint16 and returns the result
+func (c *Cache) IncrementUint16(k string, n uint16) (uint16, error) {
+	return add(c, k, n)
+}
+
+// IncrementUint32 adds n to an item of type uint32 and returns the result
+func (c *Cache) IncrementUint32(k string, n uint32) (uint32, error) {
+	return add(c, k, n)
+}
+
+// IncrementUint64 adds n to an item of type uint64 and returns the result
+func (c *Cache) IncrementUint64(k string, n uint64) (uint64, error) {
+	return add(c, k, n)
+}
+
+// IncrementFloat32 adds n to an item of type float32 and returns the result
+func (c *Cache) IncrementFloat32(k string, n float32) (float32, error) {
+	return add(c, k, n)
+}
+
+// IncrementFloat64 adds n to an item of type float64 and returns the result
+func (c *Cache) IncrementFloat64(k string, n float64) (float64, error) {
+	return add(c, k, n)
+}
+
+// DecrementInt subtracts n from an item of type int and returns the result
+func (c *Cache) DecrementInt(k string, n int) (int, error) {
+	return add(c, k, -n)
+}
+
+// DecrementInt8 subtracts n from an item of type int8 and returns the result
+func (c *Cache) DecrementInt8(k string, n int8) (int8, error) {
+	return add(c, k, -n)
+}
+
+// DecrementInt16 subtracts n from an item of type int16 and returns the result
+func (c *Cache) DecrementInt16(k string, n int16) (int16, error) {
+	return add(c, k, -n)
+}
+
+// DecrementInt32 subtracts n from an item of type int32 and returns the result
+func (c *Cache) DecrementInt32(k string, n int32) (int32, error) {
+	return add(c, k, -n)
+}
+
+// DecrementInt64 subtracts n from an item of type int64 and returns the result
+func (c *Cache) DecrementInt64(k string, n int64) (int64, error) {
+	return add(c, k, -n)
+}
+
+// DecrementUint subtracts n from an item of type uint and returns the result
+func (c *Cache) DecrementUint(k string, n uint) (uint, error) {
+	return add(c, k, -n)
+}
+
+// DecrementUintptr subtracts n from an item of type uintptr and returns the result
+func (c *Cache) DecrementUintptr(k string, n uintptr) (uintptr, error) {
+	return add(c, k, -n)
+}
+
+// DecrementUint8 subtracts n from an item of type uint8 and returns the result
+func (c *Cache) DecrementUint8(k string, n uint8) (uint8, error) {
+	return add(c, k, -n)
+}
+
+// DecrementUint16 subtracts n from an item of type uint16 and returns the result
+func (c *Cache) DecrementUint16(k string, n uint16) (uint16, error) {
+	return add(c, k, -n)
+}
+
+// DecrementUint32 subtracts n from an item of type uint32 and returns the result
+func (c *Cache) DecrementUint32(k string, n uint32) (uint32, error) {
+	return add(c, k, -n)
+}
+
+// DecrementUint64 subtracts n from an item of type uint64 and returns the result
+func (c *Cache) DecrementUint64(k string, n uint64) (uint64, error) {
+	return add(c, k, -n)
+}
+
+// DecrementFloat32 subtracts n from an item of type float32 and returns the result
+func (c *Cache) DecrementFloat32(k string, n float32) (float32, error) {
+	return add(c, k, -n)
+}
+
+// DecrementFloat64 subtracts n from an item of type float64 and returns the result
+func (c *Cache) DecrementFloat64(k string, n float64) (float64, error) {
+	return add(c, k, -n)
+}
+
+// update replaces the item k by the result of fn, failing with the message
+// of format when fn does not apply to its value
+func (c *Cache) update(k string, fn func(x interface{}) (interface{}, bool), format string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	x, item, found := c.object(k)
+	if !found {
+		return fmt.Errorf("item %s not found", k)
+	}
+	x, ok := fn(x)
+	if !ok {
+		return fmt.Errorf(format, k)
+	}
+	return c.put(k, x, item)
+}
+
+// add adds n to the item k of type T and returns the result
+func add[T number](c *Cache, k string, n T) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	x, item, found := c.object(k)
+	if !found {
+		return 0, fmt.Errorf("item %s not found", k)
+	}
+	v, ok := convert[T](x)
+	if !ok {
+		return 0, fmt.Errorf("the value for %s is not an %T", k, n)
+	}
+	v += n
+	return v, c.put(k, v, item)
+}
+
+// convert returns x as a T, the numbers read from a shared store are
+// float64 values converted when they fit
+func convert[T number](x interface{}) (T, bool) {
+	if v, ok := x.(T); ok {
+		return v, true
+	}
+	f, ok := x.(float64)
+	if !ok {
+		return 0, false
+	}
+	v := T(f)
+	return v, float64(v) == f
+}
+
+// addInt adds n to a number of any type
+func addInt(x interface{}, n int64) (interface{}, bool) {
+	switch v := x.(type) {
+	case int:
+		return v + int(n), true
+	case int8:
+		return v + int8(n), true
+	case int16:
+		return v + int16(n), true
+	case int32:
+		return v + int32(n), true
+	case int64:
+		return v + n, true
+	case uint:
+		return v + uint(n), true
+	case uintptr:
+		return v + uintptr(n), true
+	case uint8:
+		return v + uint8(n), true
+	case uint16:
+		return v + uint16(n), true
+	case uint32:
+		return v + uint32(n), true
+	case uint64:
+		return v + uint64(n), true
+	case float32:
+		return v + float32(n), true
+	case float64:
+		return v + float64(n), true
+	}
+	return nil, false
+}
+
+// addFloat adds n to a floating point number
+func addFloat(x interface{}, n float64) (interface{}, bool) {
+	switch v := x.(type) {
+	case float32:
+		return v + float32(n), true
+	case float64:
+		return v + n, true
+	}
+	return nil, false
+}
@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrNotFound may be returned by loaders for missing items, it is cached
+// like sql.ErrNoRows for the negative expiration
+var ErrNotFound = errors.New("not found")
+
+// errLoadPanicked is returned to the callers waiting on a load that panicked
+var errLoadPanicked = errors.New("cache load panicked")
+
+// Kinds of encoded values, a byte in front of the JSON
+const (
+	kindValue byte = iota
+	kindNotFound
+	kindNoRows
+)
+
+// GetValue returns the value k of type T
+func GetValue[T any](ctx context.Context, c *Cache, k string) (T, bool) {
+	v, found, err := lookup[T](ctx, c, k)
+	return v, found && err == nil
+}
+
+// SetValue stores v as k for d, or the default expiration when d is zero,
+// tagged for Invalidate
+func (c *Cache) SetValue(ctx context.Context, k string, v interface{}, d time.Duration, tags ...string) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.set(ctx, k, append([]byte{kindValue}, body...), d, tags)
+}
+
+// GetOrLoad returns the value k of type T, loading it on a miss and caching
+// it for d, or the default expiration when d is zero. Concurrent misses of a
+// key share a single load. Loads failing with ErrNotFound or sql.ErrNoRows
+// are cached for the negative expiration and fail again until then.
+func GetOrLoad[T any](ctx context.Context, c *Cache, k string, d time.Duration, load func(ctx context.Context) (T, error)) (T, error) {
+	if v, found, err := lookup[T](ctx, c, k); found {
+		return v, err
+	}
+
+	x, err := c.flight.do(ctx, k, func() (interface{}, error) {
+		v, err := load(ctx)
+		if err != nil {
+			kind := kindValue
+			switch {
+			case errors.Is(err, ErrNotFound):
+				kind = kindNotFound
+			case errors.Is(err, sql.ErrNoRows):
+				kind = kindNoRows
+			}
+			if kind != kindValue && c.negative > 0 {
+				c.keep(ctx, k, []byte{kind}, c.negative)
+			}
+			return nil, err
+		}
+		body, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		c.keep(ctx, k, append([]byte{kindValue}, body...), c.jittered(d))
+		return v, nil
+	})
+	var zero T
+	if err != nil {
+		return zero, err
+	}
+	v, _ := x.(T)
+	return v, nil
+}
+
+// lookup returns the value k, or the error of a cached failed load. Store
+// failures and values of another type are misses.
+func lookup[T any](ctx context.Context, c *Cache, k string) (T, bool, error) {
+	var zero T
+	item, found, err := c.store.Get(ctx, k)
+	if err != nil {
+		c.logger.Warn("cache lookup failed", "key", k, "error", err)
+	}
+	if !found || len(item.Value) == 0 {
+		c.record(false)
+		return zero, false, nil
+	}
+	switch item.Value[0] {
+	case kindNotFound:
+		c.record(true)
+		return zero, true, ErrNotFound
+	case kindNoRows:
+		c.record(true)
+		return zero, true, sql.ErrNoRows
+	}
+	var v T
+	if err := json.Unmarshal(item.Value[1:], &v); err != nil {
+		c.record(false)
+		return zero, false, nil
+	}
+	c.record(true)
+	return v, true, nil
+}
+
+// set stores an encoded value
+func (c *Cache) set(ctx context.Context, k string, value []byte, d time.Duration, tags []string) error {
+	return c.store.Set(ctx, k, c.item(value, d, tags))
+}
+
+// item returns an encoded value expiring after d, or the default expiration
+// when d is zero
+func (c *Cache) item(value []byte, d time.Duration, tags []string) Item {
+	item := Item{Value: value, Tags: tags}
+	if d == 0 {
+		d = c.expiration
+	}
+	if d > 0 {
+		item.Expiration = time.Now().Add(d)
+	}
+	return item
+}
+
+// keep stores a loaded value, failures are logged as the value is served
+// anyway
+func (c *Cache) keep(ctx context.Context, k string, value []byte, d time.Duration) {
+	if err := c.set(ctx, k, value, d, nil); err != nil {
+		c.logger.Warn("cache store failed", "key", k, "error", err)
+	}
+}
+
+// jittered returns the expiration d, shortened by the jitter
+func (c *Cache) jittered(d time.Duration) time.Duration {
+	if d == 0 {
+		d = c.expiration
+	}
+	if d <= 0 || c.jitter <= 0 {
+		return d
+	}
+	return d - time.Duration(rand.Float64()*c.jitter*float64(d))
+}
+
+// call is a load in flight
+type call struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// group runs a single load per key at a time
+type group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// do runs fn unless a load of k is in flight, in which case it waits for
+// its result or the end of ctx
+func (g *group) do(ctx context.Context, k string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[k]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.val, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	c := &call{done: make(chan struct{}), err: errLoadPanicked}
+	g.calls[k] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, k)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+	return c.val, c.err
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/greatfocus/gf-sframe/database"
+	"github.com/lib/pq"
+)
+
+// Postgres is a store on a table shared by every instance. The table is
+// unlogged, so it is faster to write but emptied after a database crash.
+type Postgres struct {
+	conn  *database.Conn
+	table string
+}
+
+// record is a cache row
+type record struct {
+	Key       string         `db:"key"`
+	Value     []byte         `db:"value"`
+	ExpiresAt sql.NullTime   `db:"expires_at"`
+	Tags      pq.StringArray `db:"tags"`
+}
+
+// NewPostgres creates the store and its table
+func NewPostgres(ctx context.Context, conn *database.Conn, table string) (*Postgres, error) {
+	if conn.Dialect() != database.Postgres {
+		return nil, fmt.Errorf("cache store on %s: %w", conn.Dialect().Name(), database.ErrUnsupported)
+	}
+	p := &Postgres{conn: conn, table: table}
+	if err := p.createTable(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// createTable creates the cache table and its indexes
+func (p *Postgres) createTable(ctx context.Context) error {
+	table := database.QuoteIdentifier(p.table)
+	name := p.table[strings.LastIndex(p.table, ".")+1:]
+	_, err := p.conn.Exec(ctx, fmt.Sprintf(`
+		CREATE UNLOGGED TABLE IF NOT EXISTS %[1]s (
+			key TEXT PRIMARY KEY,
+			value BYTEA NOT NULL,
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			expires_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s USING GIN (tags);
+		CREATE INDEX IF NOT EXISTS %[3]s ON %[1]s (expires_at) WHERE expires_at IS NOT NULL`,
+		table, database.QuoteIdentifier(name+"_tags"), database.QuoteIdentifier(name+"_expires")))
+	return err
+}
+
+// Get returns the item k, read from the master so that invalidations are
+// seen at once
+func (p *Postgres) Get(ctx context.Context, k string) (Item, bool, error) {
+	r, err := database.QueryOne[record](database.WithPrimary(ctx), p.conn, fmt.Sprintf(
+		"SELECT value, expires_at, tags FROM %s WHERE key = $1 AND (expires_at IS NULL OR expires_at > now())",
+		database.QuoteIdentifier(p.table)), k)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Item{}, false, nil
+	}
+	if err != nil {
+		return Item{}, false, err
+	}
+	return r.item(), true, nil
+}
+
+// item converts the row to an Item
+func (r record) item() Item {
+	item := Item{Value: r.Value, Tags: r.Tags}
+	if r.ExpiresAt.Valid {
+		item.Expiration = r.ExpiresAt.Time
+	}
+	return item
+}
+
+// Set adds the item k, replacing any existing item
+func (p *Postgres) Set(ctx context.Context, k string, item Item) error {
+	var expiresAt interface{}
+	if !item.Expiration.IsZero() {
+		expiresAt = item.Expiration
+	}
+	tags := item.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	_, err := database.Insert(p.table).
+		Columns("key", "value", "tags", "expires_at").
+		Values(k, item.Value, pq.Array(tags), expiresAt).
+		OnConflict("key").
+		DoUpdate("value", "tags", "expires_at").
+		Exec(ctx, p.conn)
+	return err
+}
+
+// Delete removes the items of keys
+func (p *Postgres) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := p.conn.Delete(ctx, fmt.Sprintf("DELETE FROM %s WHERE key = ANY($1)",
+		database.QuoteIdentifier(p.table)), pq.Array(keys))
+	return err
+}
+
+// Invalidate removes the items carrying any of the tags
+func (p *Postgres) Invalidate(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := p.conn.Delete(ctx, fmt.Sprintf("DELETE FROM %s WHERE tags && $1",
+		database.QuoteIdentifier(p.table)), pq.Array(tags))
+	return err
+}
+
+// Items returns the unexpired items
+func (p *Postgres) Items(ctx context.Context) (map[string]Item, error) {
+	records, err := database.QueryStructs[record](database.WithPrimary(ctx), p.conn, fmt.Sprintf(
+		"SELECT key, value, expires_at, tags FROM %s WHERE expires_at IS NULL OR expires_at > now()",
+		database.QuoteIdentifier(p.table)))
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[string]Item, len(records))
+	for _, r := range records {
+		items[r.Key] = r.item()
+	}
+	return items, nil
+}
+
+// Flush removes every item
+func (p *Postgres) Flush(ctx context.Context) error {
+	_, err := p.conn.Delete(ctx, "DELETE FROM "+database.QuoteIdentifier(p.table))
+	return err
+}
+
+// Purge removes the expired items
+func (p *Postgres) Purge(ctx context.Context) error {
+	_, err := p.conn.Delete(ctx, fmt.Sprintf("DELETE FROM %s WHERE expires_at <= now()",
+		database.QuoteIdentifier(p.table)))
+	return err
+}
@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Eviction policies of the memory store once it reaches its capacity
+const (
+	// EvictLRU evicts the least recently used item
+	EvictLRU = "lru"
+	// EvictFIFO evicts the oldest item
+	EvictFIFO = "fifo"
+)
+
+// Item is an encoded value held by a store
+type Item struct {
+	Value []byte
+	// Expiration is the time the item expires, zero never expires
+	Expiration time.Time
+	// Tags name groups of items deleted together by Invalidate
+	Tags []string
+	// object is the value given to Cache.Set, kept by the memory store so
+	// that it is read back without decoding
+	object interface{}
+}
+
+// expired checks if the item has expired at now
+func (i Item) expired(now time.Time) bool {
+	return !i.Expiration.IsZero() && !now.Before(i.Expiration)
+}
+
+// Store is a cache backend shared by GetOrLoad and the Cached middleware
+type Store interface {
+	// Get returns the item k unless it is missing or has expired
+	Get(ctx context.Context, k string) (Item, bool, error)
+	// Set adds the item k, replacing any existing item
+	Set(ctx context.Context, k string, item Item) error
+	// Delete removes the items of keys
+	Delete(ctx context.Context, keys ...string) error
+	// Invalidate removes the items carrying any of the tags
+	Invalidate(ctx context.Context, tags ...string) error
+	// Flush removes every item
+	Flush(ctx context.Context) error
+}
+
+// Purger is implemented by stores whose expired items are removed
+// periodically rather than on access
+type Purger interface {
+	Purge(ctx context.Context) error
+}
+
+// Lister is implemented by stores that can list their unexpired items
+type Lister interface {
+	Items(ctx context.Context) (map[string]Item, error)
+}
+
+// Memory is an in-process store holding up to capacity items, evicted by
+// the policy once it is full
+type Memory struct {
+	mu       sync.Mutex
+	capacity int
+	policy   string
+	items    map[string]*list.Element
+	order    *list.List
+	tags     map[string]map[string]struct{}
+}
+
+// memoryEntry is an element of the eviction order
+type memoryEntry struct {
+	key  string
+	item Item
+}
+
+// NewMemory creates a memory store, a zero capacity is unbounded
+func NewMemory(capacity int, policy string) (*Memory, error) {
+	switch policy {
+	case "":
+		policy = EvictLRU
+	case EvictLRU, EvictFIFO:
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", policy)
+	}
+	return &Memory{
+		capacity: capacity,
+		policy:   policy,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		tags:     make(map[string]map[string]struct{}),
+	}, nil
+}
+
+// Get returns the item k
+func (m *Memory) Get(ctx context.Context, k string) (Item, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.items[k]
+	if !ok {
+		return Item{}, false, nil
+	}
+	entry := e.Value.(*memoryEntry)
+	if entry.item.expired(time.Now()) {
+		m.remove(e)
+		return Item{}, false, nil
+	}
+	if m.policy == EvictLRU {
+		m.order.MoveToBack(e)
+	}
+	return entry.item, true, nil
+}
+
+// Set adds the item k, evicting items beyond the capacity
+func (m *Memory) Set(ctx context.Context, k string, item Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.items[k]; ok {
+		m.remove(e)
+	}
+	m.items[k] = m.order.PushBack(&memoryEntry{key: k, item: item})
+	for _, tag := range item.Tags {
+		keys, ok := m.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			m.tags[tag] = keys
+		}
+		keys[k] = struct{}{}
+	}
+	for m.capacity > 0 && m.order.Len() > m.capacity {
+		m.remove(m.order.Front())
+	}
+	return nil
+}
+
+// Delete removes the items of keys
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		if e, ok := m.items[k]; ok {
+			m.remove(e)
+		}
+	}
+	return nil
+}
+
+// Invalidate removes the items carrying any of the tags
+func (m *Memory) Invalidate(ctx context.Context, tags ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tag := range tags {
+		for k := range m.tags[tag] {
+			m.remove(m.items[k])
+		}
+	}
+	return nil
+}
+
+// Purge removes the expired items
+func (m *Memory) Purge(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for e := m.order.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*memoryEntry).item.expired(now) {
+			m.remove(e)
+		}
+		e = next
+	}
+	return nil
+}
+
+// Flush removes every item
+func (m *Memory) Flush(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items = make(map[string]*list.Element)
+	m.order.Init()
+	m.tags = make(map[string]map[string]struct{})
+	return nil
+}
+
+// Items returns the unexpired items
+func (m *Memory) Items(ctx context.Context) (map[string]Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	items := make(map[string]Item, len(m.items))
+	for k, e := range m.items {
+		if item := e.Value.(*memoryEntry).item; !item.expired(now) {
+			items[k] = item
+		}
+	}
+	return items, nil
+}
+
+// Len returns the number of items, including expired ones not yet removed
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+// remove deletes an element and its tags
+func (m *Memory) remove(e *list.Element) {
+	entry := m.order.Remove(e).(*memoryEntry)
+	delete(m.items, entry.key)
+	for _, tag := range entry.item.Tags {
+		delete(m.tags[tag], entry.key)
+		if len(m.tags[tag]) == 0 {
+			delete(m.tags, tag)
+		}
+	}
+}
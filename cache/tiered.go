@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/database"
+)
+
+// maxBroadcast keeps invalidations within the NOTIFY payload limit, larger
+// ones flush the local tier of the other instances
+const maxBroadcast = 7000
+
+// invalidation is broadcast to the other instances on every write
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Flush  bool     `json:"flush,omitempty"`
+}
+
+// TwoTier pairs a local memory store in front of a shared store. Writes go
+// to both tiers and are broadcast with NOTIFY, so that the other instances
+// drop their local copies. Local copies live at most for the local
+// expiration, which bounds staleness when a notification is missed.
+type TwoTier struct {
+	local   *Memory
+	shared  Store
+	conn    *database.Conn
+	channel string
+	origin  string
+	ttl     time.Duration
+}
+
+// NewTwoTier creates a two-tier store broadcasting on channel, local copies
+// expire after ttl, or with the shared item when ttl is zero
+func NewTwoTier(local *Memory, shared Store, conn *database.Conn, channel string, ttl time.Duration) *TwoTier {
+	origin := make([]byte, 8)
+	_, _ = rand.Read(origin)
+	return &TwoTier{
+		local:   local,
+		shared:  shared,
+		conn:    conn,
+		channel: channel,
+		origin:  hex.EncodeToString(origin),
+		ttl:     ttl,
+	}
+}
+
+// Listen applies the invalidations of the other instances to the local tier
+func (t *TwoTier) Listen(listener *database.Listener) error {
+	return listener.Handle(t.channel, func(ctx context.Context, n database.Notification) {
+		var inv invalidation
+		if err := json.Unmarshal([]byte(n.Payload), &inv); err != nil || inv.Origin == t.origin {
+			return
+		}
+		if inv.Flush {
+			_ = t.local.Flush(ctx)
+			return
+		}
+		_ = t.local.Delete(ctx, inv.Keys...)
+		_ = t.local.Invalidate(ctx, inv.Tags...)
+	})
+}
+
+// Get returns the item k from the local tier, or from the shared tier
+// keeping a local copy
+func (t *TwoTier) Get(ctx context.Context, k string) (Item, bool, error) {
+	if item, found, _ := t.local.Get(ctx, k); found {
+		return item, true, nil
+	}
+	item, found, err := t.shared.Get(ctx, k)
+	if err != nil || !found {
+		return item, found, err
+	}
+	_ = t.local.Set(ctx, k, t.localItem(item))
+	return item, true, nil
+}
+
+// Set adds the item k to both tiers
+func (t *TwoTier) Set(ctx context.Context, k string, item Item) error {
+	if err := t.shared.Set(ctx, k, item); err != nil {
+		return err
+	}
+	_ = t.local.Set(ctx, k, t.localItem(item))
+	return t.broadcast(ctx, invalidation{Keys: []string{k}})
+}
+
+// Delete removes the items of keys from both tiers
+func (t *TwoTier) Delete(ctx context.Context, keys ...string) error {
+	if err := t.shared.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	_ = t.local.Delete(ctx, keys...)
+	return t.broadcast(ctx, invalidation{Keys: keys})
+}
+
+// Invalidate removes the items carrying any of the tags from both tiers
+func (t *TwoTier) Invalidate(ctx context.Context, tags ...string) error {
+	if err := t.shared.Invalidate(ctx, tags...); err != nil {
+		return err
+	}
+	_ = t.local.Invalidate(ctx, tags...)
+	return t.broadcast(ctx, invalidation{Tags: tags})
+}
+
+// Flush removes every item from both tiers
+func (t *TwoTier) Flush(ctx context.Context) error {
+	if err := t.shared.Flush(ctx); err != nil {
+		return err
+	}
+	_ = t.local.Flush(ctx)
+	return t.broadcast(ctx, invalidation{Flush: true})
+}
+
+// Items returns the unexpired items of the shared tier
+func (t *TwoTier) Items(ctx context.Context) (map[string]Item, error) {
+	if l, ok := t.shared.(Lister); ok {
+		return l.Items(ctx)
+	}
+	return map[string]Item{}, nil
+}
+
+// Purge removes the expired items of both tiers
+func (t *TwoTier) Purge(ctx context.Context) error {
+	_ = t.local.Purge(ctx)
+	if p, ok := t.shared.(Purger); ok {
+		return p.Purge(ctx)
+	}
+	return nil
+}
+
+// localItem caps the expiration of a local copy
+func (t *TwoTier) localItem(item Item) Item {
+	if t.ttl <= 0 {
+		return item
+	}
+	if limit := time.Now().Add(t.ttl); item.Expiration.IsZero() || item.Expiration.After(limit) {
+		item.Expiration = limit
+	}
+	return item
+}
+
+// broadcast notifies the other instances of an invalidation
+func (t *TwoTier) broadcast(ctx context.Context, inv invalidation) error {
+	inv.Origin = t.origin
+	payload, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxBroadcast {
+		payload, err = json.Marshal(invalidation{Origin: t.origin, Flush: true})
+		if err != nil {
+			return err
+		}
+	}
+	return database.Notify(ctx, t.conn, t.channel, string(payload))
+}
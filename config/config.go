@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"time"
 )
 
 // Config struct
@@ -15,18 +16,48 @@ type Config struct {
 	Cache        Cache        `json:"cache"`
 	Integrations Integrations `json:"integrations"`
 	Services     Services     `json:"services"`
+	Tracing      Tracing      `json:"tracing"`
+	Admin        Admin        `json:"admin"`
+	Outbox       Outbox       `json:"outbox"`
+	Tenancy      Tenancy      `json:"tenancy"`
+	Audit        Audit        `json:"audit"`
+	Idempotency  Idempotency  `json:"idempotency"`
 }
 
 // Server struct config
 type Server struct {
-	Port           string   `json:"port"`
-	Timeout        int64    `json:"timeout"`
-	UploadPath     string   `json:"uploadPath"`
-	AllowedOrigins []string `json:"allowedOrigins"`
-	AllowedIPs     []string `json:"allowedIPs"`
-	Secure         Secure   `json:"secure"`
-	JWT            JWT      `json:"jwt"`
-	Workers        int64    `json:"workers"`
+	Port           string    `json:"port"`
+	Timeout        int64     `json:"timeout"`
+	UploadPath     string    `json:"uploadPath"`
+	AllowedOrigins []string  `json:"allowedOrigins"`
+	AllowedIPs     []string  `json:"allowedIPs"`
+	TrustedProxies []string  `json:"trustedProxies"`
+	Secure         Secure    `json:"secure"`
+	JWT            JWT       `json:"jwt"`
+	Workers        int64     `json:"workers"`
+	AccessLog      AccessLog `json:"accessLog"`
+	Metrics        Metrics   `json:"metrics"`
+	Health         Health    `json:"health"`
+	LogLevel       string    `json:"logLevel"`
+}
+
+// Health struct config
+type Health struct {
+	Timeout       Duration `json:"timeout"`
+	ShutdownDelay Duration `json:"shutdownDelay"`
+}
+
+// Metrics struct config
+type Metrics struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`
+}
+
+// AccessLog struct config
+type AccessLog struct {
+	Enabled      bool     `json:"enabled"`
+	SampleRate   float64  `json:"sampleRate"`
+	RedactParams []string `json:"redactParams"`
 }
 
 // Secure struct config
@@ -45,29 +76,144 @@ type JWT struct {
 
 // Cache struct config
 type Cache struct {
-	DefaultExpiration int64 `json:"defaultExpiration"`
-	CleanupInterval   int64 `json:"cleanupInterval"`
+	DefaultExpiration  Duration `json:"defaultExpiration"`
+	CleanupInterval    Duration `json:"cleanupInterval"`
+	NegativeExpiration Duration `json:"negativeExpiration"`
+	Jitter             float64  `json:"jitter"`
+	Backend            string   `json:"backend"`
+	Capacity           int64    `json:"capacity"`
+	Eviction           string   `json:"eviction"`
+	LocalExpiration    Duration `json:"localExpiration"`
+	Table              string   `json:"table"`
+	Channel            string   `json:"channel"`
+	Schedule           string   `json:"schedule"`
+}
+
+// Admin struct config
+type Admin struct {
+	Enabled    bool     `json:"enabled"`
+	Port       string   `json:"port"`
+	User       string   `json:"user"`
+	Password   string   `json:"password"`
+	AllowedIPs []string `json:"allowedIPs"`
+}
+
+// Outbox struct config
+type Outbox struct {
+	Enabled     bool      `json:"enabled"`
+	Table       string    `json:"table"`
+	Schedule    string    `json:"schedule"`
+	BatchSize   int64     `json:"batchSize"`
+	MaxAttempts int64     `json:"maxAttempts"`
+	Lease       Duration  `json:"lease"`
+	Webhooks    []Webhook `json:"webhooks"`
+}
+
+// Webhook struct config
+type Webhook struct {
+	Topic   string   `json:"topic"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	Timeout Duration `json:"timeout"`
+}
+
+// Audit struct config
+type Audit struct {
+	Enabled      bool     `json:"enabled"`
+	Table        string   `json:"table"`
+	RedactFields []string `json:"redactFields"`
+}
+
+// Idempotency struct config
+type Idempotency struct {
+	Enabled     bool     `json:"enabled"`
+	Store       string   `json:"store"`
+	Table       string   `json:"table"`
+	Header      string   `json:"header"`
+	Methods     []string `json:"methods"`
+	Expiration  Duration `json:"expiration"`
+	LockTimeout Duration `json:"lockTimeout"`
+	Schedule    string   `json:"schedule"`
+}
+
+// Tenancy struct config
+type Tenancy struct {
+	Enabled      bool     `json:"enabled"`
+	Sources      []string `json:"sources"`
+	Claim        string   `json:"claim"`
+	Header       string   `json:"header"`
+	TrustHeader  bool     `json:"trustHeader"`
+	Domain       string   `json:"domain"`
+	Required     bool     `json:"required"`
+	ExemptPaths  []string `json:"exemptPaths"`
+	Mode         string   `json:"mode"`
+	Variable     string   `json:"variable"`
+	SchemaPrefix string   `json:"schemaPrefix"`
+	Tenants      []string `json:"tenants"`
+}
+
+// Tracing struct config
+type Tracing struct {
+	Enabled     bool    `json:"enabled"`
+	Exporter    string  `json:"exporter"`
+	Endpoint    string  `json:"endpoint"`
+	Insecure    bool    `json:"insecure"`
+	ServiceName string  `json:"serviceName"`
+	SampleRatio float64 `json:"sampleRatio"`
 }
 
 // Database struct config
 type Database struct {
-	Master DatabaseType `json:"master"`
-	Slave  DatabaseType `json:"slave"`
+	Driver              string         `json:"driver"`
+	Master              DatabaseType   `json:"master"`
+	Slave               DatabaseType   `json:"slave"`
+	Replicas            []DatabaseType `json:"replicas"`
+	Balancer            string         `json:"balancer"`
+	HealthCheckInterval Duration       `json:"healthCheckInterval"`
+	SessionConsistency  bool           `json:"sessionConsistency"`
+	ConsistencySecret   string         `json:"consistencySecret"`
+	Channels            []string       `json:"channels"`
+	Leader              Leader         `json:"leader"`
+	SlowQueryThreshold  Duration       `json:"slowQueryThreshold"`
+	StartupTimeout      Duration       `json:"startupTimeout"`
+	ReadRetries         int64          `json:"readRetries"`
+}
+
+// Leader struct config
+type Leader struct {
+	Enabled bool     `json:"enabled"`
+	Name    string   `json:"name"`
+	TTL     Duration `json:"ttl"`
+}
+
+// ReadReplicas returns the configured replicas, using the single slave
+// of older configurations when no replica list is set
+func (d Database) ReadReplicas() []DatabaseType {
+	if len(d.Replicas) > 0 {
+		return d.Replicas
+	}
+	if d.Slave.Host != "" {
+		return []DatabaseType{d.Slave}
+	}
+	return nil
 }
 
 // DatabaseType struct config
 type DatabaseType struct {
-	Host          string `json:"host"`
-	Port          string `json:"port"`
-	Database      string `json:"database"`
-	User          string `json:"user"`
-	Password      string `json:"password"`
-	Secure        Secure `json:"secure"`
-	Timeout       int64  `json:"timeOut"`
-	MaxLifetime   int64  `json:"maxLifetime"`
-	MaxIdleConns  int64  `json:"maxIdleConns"`
-	MaxOpenConns  int64  `json:"maxOpenConns"`
-	ExecuteSchema bool   `json:"executeSchema"`
+	Host             string `json:"host"`
+	Port             string `json:"port"`
+	Database         string `json:"database"`
+	User             string `json:"user"`
+	Password         string `json:"password"`
+	Secure           Secure `json:"secure"`
+	Timeout          int64  `json:"timeOut"`
+	MaxLifetime      int64  `json:"maxLifetime"`
+	MaxIdleConns     int64  `json:"maxIdleConns"`
+	MaxOpenConns     int64  `json:"maxOpenConns"`
+	ExecuteSchema    bool   `json:"executeSchema"`
+	SchemaDryRun     bool   `json:"schemaDryRun"`
+	SchemaLeaderOnly bool   `json:"schemaLeaderOnly"`
+	RebuildIndexes   bool   `json:"rebuildIndexes"`
 }
 
 // Integrations struct config
@@ -168,6 +314,19 @@ func validateDefault(c *Config) {
 		log.Fatal(fmt.Println(err))
 	}
 
+	if c.Server.AccessLog.SampleRate < 0 || c.Server.AccessLog.SampleRate > 1 {
+		err = errors.New("please configure access log sampleRate between 0 and 1")
+		log.Fatal(fmt.Println(err))
+	}
+
+	if c.Server.Metrics.Enabled && c.Server.Metrics.Path == "" {
+		c.Server.Metrics.Path = "/metrics"
+	}
+
+	if c.Server.Health.Timeout == 0 {
+		c.Server.Health.Timeout = Duration(5 * time.Second)
+	}
+
 	if c.Server.JWT.Authorized {
 		if c.Server.JWT.Secret == "" {
 			err = errors.New("please configure JWT in settings")
@@ -180,6 +339,26 @@ func validateDefault(c *Config) {
 		}
 	}
 
+	// validate tracing
+	validateTracing(c)
+
+	// validate admin
+	validateAdmin(c)
+
+	// validate outbox
+	validateOutbox(c)
+
+	// validate tenancy
+	validateTenancy(c)
+
+	// validate audit
+	if c.Audit.Enabled && c.Audit.Table == "" {
+		c.Audit.Table = "audit_log"
+	}
+
+	// validate idempotency
+	validateIdempotency(c)
+
 	// validate database
 	validateCache(c)
 
@@ -196,14 +375,212 @@ func validateDefault(c *Config) {
 // validateEmail checks database configuration
 func validateCache(c *Config) {
 	var err error
-	if c.Cache.CleanupInterval == 0 {
-		err = errors.New("please configure Cache interval")
-		log.Fatal(fmt.Println(err))
-	}
+	// cleanupInterval is no longer used, expired items are purged on schedule
 	if c.Cache.DefaultExpiration == 0 {
 		err = errors.New("please configure Cache expiration")
 		log.Fatal(fmt.Println(err))
 	}
+	if c.Cache.Jitter < 0 || c.Cache.Jitter >= 1 {
+		err = errors.New("please configure Cache jitter between 0 and 1")
+		log.Fatal(fmt.Println(err))
+	}
+	if c.Cache.NegativeExpiration == 0 {
+		c.Cache.NegativeExpiration = Duration(30 * time.Second)
+	}
+	if c.Cache.Eviction == "" {
+		c.Cache.Eviction = "lru"
+	}
+	if c.Cache.Eviction != "lru" && c.Cache.Eviction != "fifo" {
+		err = errors.New("please configure Cache eviction as lru or fifo")
+		log.Fatal(fmt.Println(err))
+	}
+	if c.Cache.Schedule == "" {
+		c.Cache.Schedule = "*/5 * * * *"
+	}
+	switch c.Cache.Backend {
+	case "":
+		c.Cache.Backend = "memory"
+	case "memory":
+	case "postgres", "two-tier":
+		if c.Database.Driver == "sqlite" {
+			err = errors.New("please configure a postgres database for the Cache backend")
+			log.Fatal(fmt.Println(err))
+		}
+		if c.Cache.Table == "" {
+			c.Cache.Table = "cache_entries"
+		}
+		if c.Cache.Channel == "" {
+			c.Cache.Channel = "cache_invalidation"
+		}
+		if c.Cache.Backend == "two-tier" && c.Cache.LocalExpiration == 0 {
+			c.Cache.LocalExpiration = Duration(time.Minute)
+		}
+	default:
+		err = errors.New("please configure Cache backend as memory, postgres or two-tier")
+		log.Fatal(fmt.Println(err))
+	}
+}
+
+// validateAdmin checks admin server configuration
+func validateAdmin(c *Config) {
+	var err error
+	if !c.Admin.Enabled {
+		return
+	}
+	if c.Admin.Port == "" || c.Admin.Port == c.Server.Port {
+		err = errors.New("please configure admin port different from server port")
+		log.Fatal(fmt.Println(err))
+	}
+	if (c.Admin.User == "" || c.Admin.Password == "") && len(c.Admin.AllowedIPs) == 0 {
+		err = errors.New("please configure admin user and password or allowedIPs")
+		log.Fatal(fmt.Println(err))
+	}
+}
+
+// validateTracing checks tracing configuration
+func validateTracing(c *Config) {
+	var err error
+	if !c.Tracing.Enabled {
+		return
+	}
+	switch c.Tracing.Exporter {
+	case "otlp":
+		if c.Tracing.Endpoint == "" {
+			err = errors.New("please configure tracing endpoint")
+			log.Fatal(fmt.Println(err))
+		}
+	case "stdout", "memory":
+	default:
+		err = errors.New("please configure tracing exporter as otlp, stdout or memory")
+		log.Fatal(fmt.Println(err))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		err = errors.New("please configure tracing sampleRatio between 0 and 1")
+		log.Fatal(fmt.Println(err))
+	}
+	if c.Tracing.SampleRatio == 0 {
+		c.Tracing.SampleRatio = 1
+	}
+	if c.Tracing.ServiceName == "" {
+		c.Tracing.ServiceName = c.Impl
+	}
+}
+
+// validateIdempotency checks idempotency configuration
+func validateIdempotency(c *Config) {
+	var err error
+	if !c.Idempotency.Enabled {
+		return
+	}
+	switch c.Idempotency.Store {
+	case "":
+		c.Idempotency.Store = "database"
+	case "database", "cache":
+	default:
+		err = errors.New("please configure idempotency store as database or cache")
+		log.Fatal(fmt.Println(err))
+	}
+	if c.Idempotency.Table == "" {
+		c.Idempotency.Table = "idempotency_keys"
+	}
+	if c.Idempotency.Header == "" {
+		c.Idempotency.Header = "Idempotency-Key"
+	}
+	if len(c.Idempotency.Methods) == 0 {
+		c.Idempotency.Methods = []string{"POST", "PATCH"}
+	}
+	if c.Idempotency.Expiration == 0 {
+		c.Idempotency.Expiration = Duration(24 * time.Hour)
+	}
+	if c.Idempotency.LockTimeout == 0 {
+		c.Idempotency.LockTimeout = Duration(time.Minute)
+	}
+	if c.Idempotency.Schedule == "" {
+		c.Idempotency.Schedule = "0 * * * *"
+	}
+}
+
+// validateOutbox checks outbox configuration
+func validateOutbox(c *Config) {
+	var err error
+	if !c.Outbox.Enabled {
+		return
+	}
+	if c.Outbox.Table == "" {
+		c.Outbox.Table = "outbox"
+	}
+	if c.Outbox.Schedule == "" {
+		c.Outbox.Schedule = "* * * * *"
+	}
+	if c.Outbox.BatchSize == 0 {
+		c.Outbox.BatchSize = 100
+	}
+	if c.Outbox.MaxAttempts == 0 {
+		c.Outbox.MaxAttempts = 10
+	}
+	if c.Outbox.Lease == 0 {
+		// renewed while a batch is delivered, events are claimed again after it
+		c.Outbox.Lease = Duration(5 * time.Minute)
+	}
+	for i, webhook := range c.Outbox.Webhooks {
+		if webhook.Topic == "" || webhook.URL == "" {
+			err = errors.New("please configure outbox webhook topic and url")
+			log.Fatal(fmt.Println(err))
+		}
+		if webhook.Timeout == 0 {
+			c.Outbox.Webhooks[i].Timeout = Duration(10 * time.Second)
+		}
+		// the lease is extended between deliveries, at half of it
+		if c.Outbox.Lease < 2*c.Outbox.Webhooks[i].Timeout {
+			err = errors.New("please configure outbox lease of at least twice the webhook timeout")
+			log.Fatal(fmt.Println(err))
+		}
+	}
+}
+
+// validateTenancy checks multi-tenancy configuration
+func validateTenancy(c *Config) {
+	var err error
+	if !c.Tenancy.Enabled {
+		return
+	}
+	if len(c.Tenancy.Sources) == 0 {
+		c.Tenancy.Sources = []string{"claim"}
+	}
+	for _, source := range c.Tenancy.Sources {
+		switch source {
+		case "header":
+			// any client can set the header unless a gateway overwrites it
+			if !c.Tenancy.TrustHeader {
+				err = errors.New("please configure tenancy trustHeader to use the header source behind a trusted gateway")
+				log.Fatal(fmt.Println(err))
+			}
+		case "claim", "subdomain":
+		default:
+			err = errors.New("please configure tenancy sources as claim, header or subdomain")
+			log.Fatal(fmt.Println(err))
+		}
+	}
+	if c.Tenancy.Claim == "" {
+		c.Tenancy.Claim = "tenantID"
+	}
+	if c.Tenancy.Header == "" {
+		c.Tenancy.Header = "X-Tenant-ID"
+	}
+	switch c.Tenancy.Mode {
+	case "":
+		c.Tenancy.Mode = "rls"
+	case "rls", "schema":
+	default:
+		err = errors.New("please configure tenancy mode as rls or schema")
+		log.Fatal(fmt.Println(err))
+	}
+	if c.Tenancy.Variable == "" {
+		c.Tenancy.Variable = "app.tenant_id"
+	}
+	if c.Tenancy.SchemaPrefix == "" {
+		c.Tenancy.SchemaPrefix = "tenant_"
+	}
 }
 
 // validateIntegrations checks integration configuration
@@ -224,42 +601,98 @@ func validateIntegrations(c *Config) {
 // ValidateDatabase checks database configuration
 func validateDatabase(c *Config) {
 	var err error
-	if c.Database.Master.Host == "" || c.Database.Slave.Host == "" {
+	switch c.Database.Driver {
+	case "":
+		c.Database.Driver = "postgres"
+	case "postgres", "sqlite":
+	default:
+		err = errors.New("please configure database driver as postgres or sqlite")
+		log.Fatal(fmt.Println(err))
+	}
+	validateDatabaseType(c, c.Database.Master)
+	for _, replica := range c.Database.ReadReplicas() {
+		validateDatabaseType(c, replica)
+	}
+
+	if c.Database.SessionConsistency && c.Database.ConsistencySecret == "" {
+		// the write position cookie is signed so clients cannot forge it
+		c.Database.ConsistencySecret = c.Server.JWT.Secret
+		if c.Database.ConsistencySecret == "" {
+			err = errors.New("please configure database consistencySecret")
+			log.Fatal(fmt.Println(err))
+		}
+	}
+
+	if c.Database.StartupTimeout == 0 {
+		c.Database.StartupTimeout = Duration(time.Minute)
+	}
+	if c.Database.ReadRetries == 0 {
+		// zero means unset, a negative readRetries disables the retries
+		c.Database.ReadRetries = 2
+	}
+
+	if c.Database.Leader.Enabled {
+		if c.Database.Leader.Name == "" {
+			c.Database.Leader.Name = c.Impl + "-leader"
+		}
+		if c.Database.Leader.TTL == 0 {
+			c.Database.Leader.TTL = Duration(30 * time.Second)
+		}
+	}
+
+	switch c.Database.Balancer {
+	case "":
+		c.Database.Balancer = "round-robin"
+	case "round-robin", "least-connections":
+	default:
+		err = errors.New("please configure database balancer as round-robin or least-connections")
+		log.Fatal(fmt.Println(err))
+	}
+}
+
+// validateDatabaseType checks a single database configuration
+func validateDatabaseType(c *Config, d DatabaseType) {
+	var err error
+	if c.Database.Driver == "sqlite" {
+		// a file or in-memory database needs no server settings
+		return
+	}
+	if d.Host == "" {
 		err = errors.New("please configure database host")
 		log.Fatal(fmt.Println(err))
 	}
-	if c.Database.Master.Port == "" || c.Database.Slave.Port == "" {
+	if d.Port == "" {
 		err = errors.New("please configure database port")
 		log.Fatal(fmt.Println(err))
 	}
-	if c.Database.Master.Database == "" || c.Database.Slave.Database == "" {
+	if d.Database == "" {
 		err = errors.New("please configure database name")
 		log.Fatal(fmt.Println(err))
 	}
-	if c.Database.Master.User == "" || c.Database.Slave.User == "" {
+	if d.User == "" {
 		err = errors.New("please configure database user")
 		log.Fatal(fmt.Println(err))
 	}
-	if c.Database.Master.Password == "" || c.Database.Slave.Password == "" {
+	if d.Password == "" {
 		err = errors.New("please configure database user")
 		log.Fatal(fmt.Println(err))
 	}
 	if c.Env == "prod" {
-		if !c.Database.Master.Secure.SslMode || !c.Database.Slave.Secure.SslMode {
+		if !d.Secure.SslMode {
 			err = errors.New("please configure secure ssl mode")
 			log.Fatal(fmt.Println(err))
 		}
 	}
 
-	if c.Database.Master.MaxOpenConns == 0 || c.Database.Slave.MaxOpenConns == 0 {
+	if d.MaxOpenConns == 0 {
 		err = errors.New("please configure database MaxOpenConns")
 		log.Fatal(fmt.Println(err))
 	}
-	if c.Database.Master.MaxIdleConns == 0 || c.Database.Slave.MaxOpenConns == 0 {
+	if d.MaxIdleConns == 0 {
 		err = errors.New("please configure database MaxIdleConns")
 		log.Fatal(fmt.Println(err))
 	}
-	if c.Database.Master.MaxLifetime == 0 || c.Database.Slave.MaxOpenConns == 0 {
+	if d.MaxLifetime == 0 {
 		err = errors.New("please configure database MaxLifetime")
 		log.Fatal(fmt.Println(err))
 	}
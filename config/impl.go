@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -14,11 +15,13 @@ import (
 
 // Impl struct
 type Impl struct {
-	Vault       string            `json:"vault"`
-	Application string            `json:"application"`
-	Impl        string            `json:"impl"`
-	Env         string            `json:"env"`
-	Scripts     map[string]string `json:"scripts"`
+	Vault            string            `json:"vault"`
+	Application      string            `json:"application"`
+	Impl             string            `json:"impl"`
+	Env              string            `json:"env"`
+	Scripts          map[string]string `json:"scripts"`
+	Migrations       fs.FS             `json:"-"`
+	TenantMigrations fs.FS             `json:"-"`
 }
 
 // GetConfig method gets configf from impl
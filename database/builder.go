@@ -0,0 +1,619 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMissingWhere is returned when an UPDATE or DELETE would affect every
+// row of a table without calling All
+var ErrMissingWhere = errors.New("update or delete without a where clause")
+
+// QuoteIdentifier quotes a possibly schema qualified identifier such as
+// public.users, doubling any quote inside it. A * part is left as is.
+func QuoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if part == "*" {
+			continue
+		}
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteRef quotes a table or column reference with an optional alias,
+// written as "users u" or "users AS u"
+func quoteRef(ref string) string {
+	fields := strings.Fields(ref)
+	switch {
+	case len(fields) == 2:
+		return QuoteIdentifier(fields[0]) + " AS " + QuoteIdentifier(fields[1])
+	case len(fields) == 3 && strings.EqualFold(fields[1], "AS"):
+		return QuoteIdentifier(fields[0]) + " AS " + QuoteIdentifier(fields[2])
+	}
+	return QuoteIdentifier(ref)
+}
+
+// quoteRefs quotes each reference
+func quoteRefs(refs []string) []string {
+	quoted := make([]string, len(refs))
+	for i, ref := range refs {
+		quoted[i] = quoteRef(ref)
+	}
+	return quoted
+}
+
+// expr is a SQL fragment using ? placeholders for its args
+type expr struct {
+	sql  string
+	args []interface{}
+}
+
+// where holds the conditions of a statement, joined with AND
+type where struct {
+	conditions []expr
+}
+
+// add appends a condition
+func (w *where) add(condition string, args []interface{}) {
+	w.conditions = append(w.conditions, expr{sql: condition, args: args})
+}
+
+// addIn appends a column IN (...) condition
+func (w *where) addIn(column string, values []interface{}) {
+	if len(values) == 0 {
+		w.add("FALSE", nil)
+		return
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	w.add(QuoteIdentifier(column)+" IN ("+placeholders+")", values)
+}
+
+// write appends the WHERE clause to sb
+func (w *where) write(sb *strings.Builder, args *[]interface{}) {
+	for i, condition := range w.conditions {
+		if i == 0 {
+			sb.WriteString(" WHERE ")
+		} else {
+			sb.WriteString(" AND ")
+		}
+		sb.WriteString("(" + condition.sql + ")")
+		*args = append(*args, condition.args...)
+	}
+}
+
+// returning writes the RETURNING clause to sb
+func returning(sb *strings.Builder, columns []string) {
+	if len(columns) > 0 {
+		sb.WriteString(" RETURNING " + strings.Join(quoteRefs(columns), ", "))
+	}
+}
+
+// rebind replaces the ? placeholders of query with the placeholders of the
+// dialect outside of quoted strings and identifiers, a doubled ?? is kept
+// as a single ?
+func rebind(d Dialect, query string, args []interface{}) (string, error) {
+	var sb strings.Builder
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '?' && i+1 < len(query) && query[i+1] == '?':
+			i++
+		case ch == '?':
+			n++
+			sb.WriteString(d.Placeholder(n))
+			continue
+		}
+		sb.WriteByte(ch)
+	}
+	if n != len(args) {
+		return "", fmt.Errorf("query has %d placeholders but %d arguments", n, len(args))
+	}
+	return sb.String(), nil
+}
+
+// SelectBuilder builds a SELECT statement
+type SelectBuilder struct {
+	columns []expr
+	from    string
+	joins   []expr
+	where   where
+	groupBy []string
+	having  where
+	orderBy []string
+	limit   *int
+	offset  *int
+}
+
+// Select starts a SELECT of columns, which may be qualified and aliased
+// such as "u.name AS user_name"
+func Select(columns ...string) *SelectBuilder {
+	b := &SelectBuilder{}
+	for _, column := range columns {
+		b.columns = append(b.columns, expr{sql: quoteRef(column)})
+	}
+	return b
+}
+
+// Expr adds a raw expression such as count(*) to the selected columns
+func (b *SelectBuilder) Expr(sql string, args ...interface{}) *SelectBuilder {
+	b.columns = append(b.columns, expr{sql: sql, args: args})
+	return b
+}
+
+// From sets the table, optionally aliased such as "users u"
+func (b *SelectBuilder) From(table string) *SelectBuilder {
+	b.from = quoteRef(table)
+	return b
+}
+
+// Join adds an INNER JOIN of table on a raw condition
+func (b *SelectBuilder) Join(table, on string, args ...interface{}) *SelectBuilder {
+	return b.join("JOIN", table, on, args)
+}
+
+// LeftJoin adds a LEFT JOIN of table on a raw condition
+func (b *SelectBuilder) LeftJoin(table, on string, args ...interface{}) *SelectBuilder {
+	return b.join("LEFT JOIN", table, on, args)
+}
+
+// join adds a join of kind
+func (b *SelectBuilder) join(kind, table, on string, args []interface{}) *SelectBuilder {
+	b.joins = append(b.joins, expr{sql: " " + kind + " " + quoteRef(table) + " ON " + on, args: args})
+	return b
+}
+
+// Where adds a raw condition using ? placeholders
+func (b *SelectBuilder) Where(condition string, args ...interface{}) *SelectBuilder {
+	b.where.add(condition, args)
+	return b
+}
+
+// WhereIf adds the condition only when ok is true
+func (b *SelectBuilder) WhereIf(ok bool, condition string, args ...interface{}) *SelectBuilder {
+	if ok {
+		b.where.add(condition, args)
+	}
+	return b
+}
+
+// WhereIn adds a column IN (...) condition, an empty list matches nothing
+func (b *SelectBuilder) WhereIn(column string, values ...interface{}) *SelectBuilder {
+	b.where.addIn(column, values)
+	return b
+}
+
+// GroupBy adds columns to the GROUP BY clause
+func (b *SelectBuilder) GroupBy(columns ...string) *SelectBuilder {
+	b.groupBy = append(b.groupBy, columns...)
+	return b
+}
+
+// Having adds a raw condition to the HAVING clause
+func (b *SelectBuilder) Having(condition string, args ...interface{}) *SelectBuilder {
+	b.having.add(condition, args)
+	return b
+}
+
+// OrderBy adds a column to the ORDER BY clause
+func (b *SelectBuilder) OrderBy(column string, desc bool) *SelectBuilder {
+	order := QuoteIdentifier(column) + " ASC"
+	if desc {
+		order = QuoteIdentifier(column) + " DESC"
+	}
+	b.orderBy = append(b.orderBy, order)
+	return b
+}
+
+// Limit sets the maximum number of rows
+func (b *SelectBuilder) Limit(limit int) *SelectBuilder {
+	b.limit = &limit
+	return b
+}
+
+// Offset sets the number of rows to skip
+func (b *SelectBuilder) Offset(offset int) *SelectBuilder {
+	b.offset = &offset
+	return b
+}
+
+// Build returns the statement for Postgres and its arguments
+func (b *SelectBuilder) Build() (string, []interface{}, error) {
+	return b.BuildFor(Postgres)
+}
+
+// BuildFor returns the statement for dialect d and its arguments
+func (b *SelectBuilder) BuildFor(d Dialect) (string, []interface{}, error) {
+	if b.from == "" {
+		return "", nil, errors.New("select without a table")
+	}
+	var sb strings.Builder
+	var args []interface{}
+	sb.WriteString("SELECT ")
+	if len(b.columns) == 0 {
+		sb.WriteString("*")
+	}
+	for i, column := range b.columns {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(column.sql)
+		args = append(args, column.args...)
+	}
+	sb.WriteString(" FROM " + b.from)
+	for _, join := range b.joins {
+		sb.WriteString(join.sql)
+		args = append(args, join.args...)
+	}
+	b.where.write(&sb, &args)
+	if len(b.groupBy) > 0 {
+		sb.WriteString(" GROUP BY " + strings.Join(quoteRefs(b.groupBy), ", "))
+	}
+	if len(b.having.conditions) > 0 {
+		var having strings.Builder
+		b.having.write(&having, &args)
+		sb.WriteString(strings.Replace(having.String(), " WHERE ", " HAVING ", 1))
+	}
+	if len(b.orderBy) > 0 {
+		sb.WriteString(" ORDER BY " + strings.Join(b.orderBy, ", "))
+	}
+	if b.limit != nil {
+		sb.WriteString(" LIMIT ?")
+		args = append(args, *b.limit)
+	}
+	if b.offset != nil {
+		sb.WriteString(" OFFSET ?")
+		args = append(args, *b.offset)
+	}
+	query, err := rebind(d, sb.String(), args)
+	return query, args, err
+}
+
+// Query runs the statement on a read replica or the transaction of q
+func (b *SelectBuilder) Query(ctx context.Context, q Querier) (*Rows, error) {
+	query, args, err := b.BuildFor(dialectOf(q))
+	if err != nil {
+		return nil, err
+	}
+	return q.Query(ctx, query, args...)
+}
+
+// InsertBuilder builds an INSERT or an upsert statement
+type InsertBuilder struct {
+	table     string
+	columns   []string
+	rows      [][]interface{}
+	conflict  []string
+	doNothing bool
+	doUpdate  bool
+	updates   []string
+	returning []string
+}
+
+// Insert starts an INSERT into table
+func Insert(table string) *InsertBuilder {
+	return &InsertBuilder{table: table}
+}
+
+// Columns sets the inserted columns
+func (b *InsertBuilder) Columns(columns ...string) *InsertBuilder {
+	b.columns = columns
+	return b
+}
+
+// Values adds a row of values in the order of the columns
+func (b *InsertBuilder) Values(values ...interface{}) *InsertBuilder {
+	b.rows = append(b.rows, values)
+	return b
+}
+
+// OnConflict sets the conflict target of an upsert
+func (b *InsertBuilder) OnConflict(columns ...string) *InsertBuilder {
+	b.conflict = columns
+	return b
+}
+
+// DoNothing skips rows that conflict
+func (b *InsertBuilder) DoNothing() *InsertBuilder {
+	b.doNothing = true
+	return b
+}
+
+// DoUpdate updates columns of conflicting rows with the inserted values,
+// without columns every inserted column outside the conflict target
+func (b *InsertBuilder) DoUpdate(columns ...string) *InsertBuilder {
+	b.doUpdate = true
+	b.updates = columns
+	return b
+}
+
+// Returning sets the RETURNING columns
+func (b *InsertBuilder) Returning(columns ...string) *InsertBuilder {
+	b.returning = columns
+	return b
+}
+
+// Build returns the statement for Postgres and its arguments
+func (b *InsertBuilder) Build() (string, []interface{}, error) {
+	return b.BuildFor(Postgres)
+}
+
+// BuildFor returns the statement for dialect d and its arguments
+func (b *InsertBuilder) BuildFor(d Dialect) (string, []interface{}, error) {
+	if len(b.columns) == 0 || len(b.rows) == 0 {
+		return "", nil, errors.New("insert without columns or values")
+	}
+	var sb strings.Builder
+	var args []interface{}
+	sb.WriteString("INSERT INTO " + QuoteIdentifier(b.table))
+	sb.WriteString(" (" + strings.Join(quoteRefs(b.columns), ", ") + ") VALUES ")
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(b.columns)), ", ") + ")"
+	for i, values := range b.rows {
+		if len(values) != len(b.columns) {
+			return "", nil, fmt.Errorf("insert row %d has %d values for %d columns", i, len(values), len(b.columns))
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(row)
+		args = append(args, values...)
+	}
+	updates := b.updates
+	if b.doUpdate && len(updates) == 0 {
+		for _, column := range b.columns {
+			if !contains(b.conflict, column) {
+				updates = append(updates, column)
+			}
+		}
+	}
+	switch {
+	case b.doNothing:
+		sb.WriteString(" " + d.Upsert(b.conflict, nil))
+	case b.doUpdate:
+		if len(updates) == 0 {
+			return "", nil, errors.New("upsert without columns to update")
+		}
+		sb.WriteString(" " + d.Upsert(b.conflict, updates))
+	}
+	returning(&sb, b.returning)
+	query, err := rebind(d, sb.String(), args)
+	return query, args, err
+}
+
+// Exec runs the statement on the master or the transaction of e
+func (b *InsertBuilder) Exec(ctx context.Context, e Executor) (sql.Result, error) {
+	query, args, err := b.BuildFor(dialectOf(e))
+	if err != nil {
+		return nil, err
+	}
+	return e.Exec(ctx, query, args...)
+}
+
+// Query runs the statement on the master or the transaction of e and
+// returns the RETURNING rows
+func (b *InsertBuilder) Query(ctx context.Context, e Executor) (*Rows, error) {
+	query, args, err := b.BuildFor(dialectOf(e))
+	if err != nil {
+		return nil, err
+	}
+	return e.Write(ctx, query, args...)
+}
+
+// UpdateBuilder builds an UPDATE statement
+type UpdateBuilder struct {
+	table     string
+	sets      []expr
+	where     where
+	all       bool
+	returning []string
+}
+
+// Update starts an UPDATE of table
+func Update(table string) *UpdateBuilder {
+	return &UpdateBuilder{table: table}
+}
+
+// Set assigns value to column
+func (b *UpdateBuilder) Set(column string, value interface{}) *UpdateBuilder {
+	b.sets = append(b.sets, expr{sql: QuoteIdentifier(column) + " = ?", args: []interface{}{value}})
+	return b
+}
+
+// SetIf assigns value to column only when ok is true
+func (b *UpdateBuilder) SetIf(ok bool, column string, value interface{}) *UpdateBuilder {
+	if ok {
+		b.Set(column, value)
+	}
+	return b
+}
+
+// SetExpr assigns a raw expression such as version + 1 to column
+func (b *UpdateBuilder) SetExpr(column, sql string, args ...interface{}) *UpdateBuilder {
+	b.sets = append(b.sets, expr{sql: QuoteIdentifier(column) + " = " + sql, args: args})
+	return b
+}
+
+// Where adds a raw condition using ? placeholders
+func (b *UpdateBuilder) Where(condition string, args ...interface{}) *UpdateBuilder {
+	b.where.add(condition, args)
+	return b
+}
+
+// WhereIf adds the condition only when ok is true
+func (b *UpdateBuilder) WhereIf(ok bool, condition string, args ...interface{}) *UpdateBuilder {
+	if ok {
+		b.where.add(condition, args)
+	}
+	return b
+}
+
+// WhereIn adds a column IN (...) condition, an empty list matches nothing
+func (b *UpdateBuilder) WhereIn(column string, values ...interface{}) *UpdateBuilder {
+	b.where.addIn(column, values)
+	return b
+}
+
+// All allows the update to run without a where clause
+func (b *UpdateBuilder) All() *UpdateBuilder {
+	b.all = true
+	return b
+}
+
+// Returning sets the RETURNING columns
+func (b *UpdateBuilder) Returning(columns ...string) *UpdateBuilder {
+	b.returning = columns
+	return b
+}
+
+// Build returns the statement for Postgres and its arguments
+func (b *UpdateBuilder) Build() (string, []interface{}, error) {
+	return b.BuildFor(Postgres)
+}
+
+// BuildFor returns the statement for dialect d and its arguments
+func (b *UpdateBuilder) BuildFor(d Dialect) (string, []interface{}, error) {
+	if len(b.sets) == 0 {
+		return "", nil, errors.New("update without columns")
+	}
+	if len(b.where.conditions) == 0 && !b.all {
+		return "", nil, ErrMissingWhere
+	}
+	var sb strings.Builder
+	var args []interface{}
+	sb.WriteString("UPDATE " + QuoteIdentifier(b.table) + " SET ")
+	for i, set := range b.sets {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(set.sql)
+		args = append(args, set.args...)
+	}
+	b.where.write(&sb, &args)
+	returning(&sb, b.returning)
+	query, err := rebind(d, sb.String(), args)
+	return query, args, err
+}
+
+// Exec runs the statement on the master or the transaction of e
+func (b *UpdateBuilder) Exec(ctx context.Context, e Executor) (sql.Result, error) {
+	query, args, err := b.BuildFor(dialectOf(e))
+	if err != nil {
+		return nil, err
+	}
+	return e.Exec(ctx, query, args...)
+}
+
+// Query runs the statement on the master or the transaction of e and
+// returns the RETURNING rows
+func (b *UpdateBuilder) Query(ctx context.Context, e Executor) (*Rows, error) {
+	query, args, err := b.BuildFor(dialectOf(e))
+	if err != nil {
+		return nil, err
+	}
+	return e.Write(ctx, query, args...)
+}
+
+// DeleteBuilder builds a DELETE statement
+type DeleteBuilder struct {
+	table     string
+	where     where
+	all       bool
+	returning []string
+}
+
+// Delete starts a DELETE from table
+func Delete(table string) *DeleteBuilder {
+	return &DeleteBuilder{table: table}
+}
+
+// Where adds a raw condition using ? placeholders
+func (b *DeleteBuilder) Where(condition string, args ...interface{}) *DeleteBuilder {
+	b.where.add(condition, args)
+	return b
+}
+
+// WhereIf adds the condition only when ok is true
+func (b *DeleteBuilder) WhereIf(ok bool, condition string, args ...interface{}) *DeleteBuilder {
+	if ok {
+		b.where.add(condition, args)
+	}
+	return b
+}
+
+// WhereIn adds a column IN (...) condition, an empty list matches nothing
+func (b *DeleteBuilder) WhereIn(column string, values ...interface{}) *DeleteBuilder {
+	b.where.addIn(column, values)
+	return b
+}
+
+// All allows the delete to run without a where clause
+func (b *DeleteBuilder) All() *DeleteBuilder {
+	b.all = true
+	return b
+}
+
+// Returning sets the RETURNING columns
+func (b *DeleteBuilder) Returning(columns ...string) *DeleteBuilder {
+	b.returning = columns
+	return b
+}
+
+// Build returns the statement for Postgres and its arguments
+func (b *DeleteBuilder) Build() (string, []interface{}, error) {
+	return b.BuildFor(Postgres)
+}
+
+// BuildFor returns the statement for dialect d and its arguments
+func (b *DeleteBuilder) BuildFor(d Dialect) (string, []interface{}, error) {
+	if len(b.where.conditions) == 0 && !b.all {
+		return "", nil, ErrMissingWhere
+	}
+	var sb strings.Builder
+	var args []interface{}
+	sb.WriteString("DELETE FROM " + QuoteIdentifier(b.table))
+	b.where.write(&sb, &args)
+	returning(&sb, b.returning)
+	query, err := rebind(d, sb.String(), args)
+	return query, args, err
+}
+
+// Exec runs the statement on the master or the transaction of e
+func (b *DeleteBuilder) Exec(ctx context.Context, e Executor) (sql.Result, error) {
+	query, args, err := b.BuildFor(dialectOf(e))
+	if err != nil {
+		return nil, err
+	}
+	return e.Exec(ctx, query, args...)
+}
+
+// Query runs the statement on the master or the transaction of e and
+// returns the RETURNING rows
+func (b *DeleteBuilder) Query(ctx context.Context, e Executor) (*Rows, error) {
+	query, args, err := b.BuildFor(dialectOf(e))
+	if err != nil {
+		return nil, err
+	}
+	return e.Write(ctx, query, args...)
+}
+
+// contains checks if values holds value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Number of bind parameters Postgres and SQLite accept per statement
+const (
+	maxParams       = 65535
+	sqliteMaxParams = 32766
+)
+
+// defaultCopyChunk is the number of rows between progress reports of COPY
+const defaultCopyChunk = 10000
+
+// BulkOptions struct
+type BulkOptions struct {
+	// ChunkSize is the number of rows per INSERT statement, or between
+	// progress reports of COPY. Inserts are always kept under the
+	// parameter limit.
+	ChunkSize int
+	// Progress is called with the number of rows written so far
+	Progress func(rows int64)
+}
+
+// SliceRows iterates over rows held in memory
+func SliceRows(rows [][]interface{}) iter.Seq2[[]interface{}, error] {
+	return func(yield func([]interface{}, error) bool) {
+		for _, row := range rows {
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
+
+// BulkInsert writes rows to table with multi-row INSERT statements in one
+// transaction, joining the transaction of ctx if any. It returns the number
+// of rows written.
+func (c *Conn) BulkInsert(ctx context.Context, table string, columns []string, rows iter.Seq2[[]interface{}, error], opts *BulkOptions) (int64, error) {
+	if len(columns) == 0 {
+		return 0, fmt.Errorf("bulk insert into %s without columns", table)
+	}
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+	chunk := maxParams / len(columns)
+	if c.master.dialect == SQLite {
+		chunk = sqliteMaxParams / len(columns)
+	}
+	if opts.ChunkSize > 0 {
+		chunk = min(chunk, opts.ChunkSize)
+	}
+
+	var written int64
+	err := c.WithTx(ctx, &TxOptions{Retries: -1}, func(t Tx) error {
+		insert := Insert(table).Columns(columns...)
+		pending := 0
+		flush := func() error {
+			if pending == 0 {
+				return nil
+			}
+			if _, err := insert.Exec(ctx, t); err != nil {
+				return err
+			}
+			written += int64(pending)
+			insert, pending = Insert(table).Columns(columns...), 0
+			if opts.Progress != nil {
+				opts.Progress(written)
+			}
+			return nil
+		}
+
+		for row, err := range rows {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			insert.Values(row...)
+			if pending++; pending == chunk {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return flush()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+// CopyFrom streams rows into table with COPY FROM STDIN in one transaction,
+// joining the transaction of ctx if any. It returns the number of rows
+// written. Drivers without COPY fall back to BulkInsert.
+func (c *Conn) CopyFrom(ctx context.Context, table string, columns []string, rows iter.Seq2[[]interface{}, error], opts *BulkOptions) (int64, error) {
+	if c.master.dialect != Postgres {
+		return c.BulkInsert(ctx, table, columns, rows, opts)
+	}
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+	chunk := opts.ChunkSize
+	if chunk <= 0 {
+		chunk = defaultCopyChunk
+	}
+
+	var written int64
+	err := c.WithTx(ctx, &TxOptions{Retries: -1}, func(t Tx) (err error) {
+		ctx, call := c.master.start(ctx, "copy", "COPY "+QuoteIdentifier(table)+" FROM STDIN", nil)
+		defer func() { call.end(err) }()
+
+		statement := pq.CopyIn(table, columns...)
+		if schema, name, ok := strings.Cut(table, "."); ok {
+			statement = pq.CopyInSchema(schema, name, columns...)
+		}
+		stmt, err := t.(*tx).sqlTx.PrepareContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		var count int64
+		for row, err := range rows {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, err := stmt.ExecContext(ctx, row...); err != nil {
+				return err
+			}
+			if count++; count%int64(chunk) == 0 && opts.Progress != nil {
+				opts.Progress(count)
+			}
+		}
+
+		// an empty exec flushes the buffered rows
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			return err
+		}
+		written = count
+		if opts.Progress != nil && count%int64(chunk) != 0 {
+			opts.Progress(count)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return written, nil
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LSN is a position in the Postgres write-ahead log
+type LSN uint64
+
+// ParseLSN parses the textual form of an LSN such as 16/B374D848
+func ParseLSN(s string) (LSN, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid lsn %q", s)
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lsn %q", s)
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lsn %q", s)
+	}
+	return LSN(h<<32 | l), nil
+}
+
+// String returns the textual form of the LSN
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint64(l)>>32, uint64(l)&0xFFFFFFFF)
+}
+
+// LagProvider reports write-ahead log positions of the databases
+type LagProvider interface {
+	// CurrentLSN returns the current write position of the master
+	CurrentLSN(ctx context.Context) (LSN, error)
+	// ReplayLSN returns the position the named replica has replayed
+	ReplayLSN(ctx context.Context, replica string) (LSN, error)
+}
+
+// sessionKey is the context key of the consistency session
+type sessionKey struct{}
+
+// Session tracks the last write of a client so that its later reads
+// observe that write
+type Session struct {
+	mu  sync.Mutex
+	lsn LSN
+}
+
+// NewSession creates a session that must observe writes up to lsn
+func NewSession(lsn LSN) *Session {
+	return &Session{lsn: lsn}
+}
+
+// LSN returns the position reads in the session must observe
+func (s *Session) LSN() LSN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lsn
+}
+
+// advance moves the session forward to lsn
+func (s *Session) advance(lsn LSN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lsn > s.lsn {
+		s.lsn = lsn
+	}
+}
+
+// WithSession returns a context whose reads observe the writes of session
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// SessionFromContext returns the consistency session carried by ctx
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	session, ok := ctx.Value(sessionKey{}).(*Session)
+	return session, ok
+}
+
+// SetLagProvider replaces the provider of write-ahead log positions
+func (c *Conn) SetLagProvider(provider LagProvider) {
+	c.lag = provider
+}
+
+// recordWrite advances the session in ctx to the current master position
+// after a successful write, reads without replicas need no position
+func (c *Conn) recordWrite(ctx context.Context) {
+	session, ok := SessionFromContext(ctx)
+	if !ok || len(c.replicas) == 0 {
+		return
+	}
+	lsn, err := c.lag.CurrentLSN(context.WithoutCancel(ctx))
+	if err != nil {
+		// without a position the session can only stay on the master
+		session.advance(LSN(^uint64(0)))
+		return
+	}
+	session.advance(lsn)
+}
+
+// caughtUp checks if the replica has replayed past lsn
+func (c *Conn) caughtUp(ctx context.Context, replica *db, lsn LSN) bool {
+	if LSN(replica.replayed.Load()) >= lsn {
+		return true
+	}
+	replayed, err := c.lag.ReplayLSN(ctx, replica.name)
+	if err != nil {
+		return false
+	}
+	replica.replayed.Store(uint64(replayed))
+	return replayed >= lsn
+}
+
+// pgLagProvider reads write-ahead log positions from Postgres
+type pgLagProvider struct {
+	conn *Conn
+}
+
+// CurrentLSN returns the current write position of the master
+func (p pgLagProvider) CurrentLSN(ctx context.Context) (LSN, error) {
+	return queryLSN(ctx, p.conn.master.conn, "SELECT pg_current_wal_lsn()::text")
+}
+
+// ReplayLSN returns the position the named replica has replayed
+func (p pgLagProvider) ReplayLSN(ctx context.Context, replica string) (LSN, error) {
+	for _, r := range p.conn.replicas {
+		if r.name == replica {
+			return queryLSN(ctx, r.conn, "SELECT pg_last_wal_replay_lsn()::text")
+		}
+	}
+	return 0, fmt.Errorf("unknown database %q", replica)
+}
+
+// queryLSN reads a single LSN value
+func queryLSN(ctx context.Context, conn *sql.DB, query string) (LSN, error) {
+	var lsn sql.NullString
+	if err := conn.QueryRowContext(ctx, query).Scan(&lsn); err != nil {
+		return 0, err
+	}
+	if !lsn.Valid {
+		return 0, fmt.Errorf("database is not replaying")
+	}
+	return ParseLSN(lsn.String)
+}
@@ -0,0 +1,145 @@
+package database_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/greatfocus/gf-sframe/config"
+	"github.com/greatfocus/gf-sframe/database"
+	_ "github.com/greatfocus/gf-sframe/database/sqlite"
+)
+
+// fakeLag reports the positions set by the test
+type fakeLag struct {
+	current  atomic.Uint64
+	replayed atomic.Uint64
+	calls    atomic.Int64
+}
+
+func (f *fakeLag) CurrentLSN(ctx context.Context) (database.LSN, error) {
+	f.calls.Add(1)
+	return database.LSN(f.current.Load()), nil
+}
+
+func (f *fakeLag) ReplayLSN(ctx context.Context, replica string) (database.LSN, error) {
+	return database.LSN(f.replayed.Load()), nil
+}
+
+// openReplicated opens a master and a replica on two SQLite files whose
+// origin table names the database serving a read
+func openReplicated(t *testing.T) *database.Conn {
+	t.Helper()
+	dir := t.TempDir()
+	dbConfig := config.Database{
+		Driver:   "sqlite",
+		Master:   config.DatabaseType{Database: filepath.Join(dir, "master.db")},
+		Replicas: []config.DatabaseType{{Database: filepath.Join(dir, "replica.db")}},
+	}
+	for _, file := range []config.DatabaseType{dbConfig.Master, dbConfig.Replicas[0]} {
+		conn, err := database.Open(config.Database{Driver: "sqlite", Master: file})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		if _, err := conn.Exec(ctx, "CREATE TABLE origin (name TEXT)"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Exec(ctx, "INSERT INTO origin VALUES ($1)", filepath.Base(file.Database)); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn, err := database.Open(dbConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// origin returns the database serving a read in ctx
+func origin(t *testing.T, ctx context.Context, conn *database.Conn) string {
+	t.Helper()
+	var name string
+	if err := conn.Select(ctx, "SELECT name FROM origin").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestSessionReadsOwnWrites(t *testing.T) {
+	conn := openReplicated(t)
+	lag := &fakeLag{}
+	conn.SetLagProvider(lag)
+	lag.current.Store(10)
+	lag.replayed.Store(5)
+
+	session := database.NewSession(0)
+	ctx := database.WithSession(context.Background(), session)
+	if got := origin(t, ctx, conn); got != "replica.db" {
+		t.Fatalf("read before any write served by %s, want the replica", got)
+	}
+
+	if _, err := conn.Exec(ctx, "UPDATE origin SET name = name"); err != nil {
+		t.Fatal(err)
+	}
+	if session.LSN() != 10 {
+		t.Fatalf("session at %s after the write, want 0/A", session.LSN())
+	}
+	if got := origin(t, ctx, conn); got != "master.db" {
+		t.Fatalf("read behind the replica served by %s, want the master", got)
+	}
+
+	lag.replayed.Store(10)
+	if got := origin(t, ctx, conn); got != "replica.db" {
+		t.Fatalf("read after the replica caught up served by %s, want the replica", got)
+	}
+}
+
+func TestSessionSkipsFailedWrites(t *testing.T) {
+	conn := openReplicated(t)
+	lag := &fakeLag{}
+	conn.SetLagProvider(lag)
+	lag.current.Store(10)
+
+	session := database.NewSession(0)
+	ctx := database.WithSession(context.Background(), session)
+	_, err := conn.Exec(ctx, "UPDATE missing SET name = name")
+	if err == nil {
+		t.Fatal("write to a missing table succeeded")
+	}
+	if session.LSN() != 0 || lag.calls.Load() != 0 {
+		t.Fatalf("failed write moved the session to %s with %d position reads", session.LSN(), lag.calls.Load())
+	}
+	if row := conn.Insert(ctx, "INSERT INTO missing VALUES (1) RETURNING 1"); row.Err() == nil {
+		t.Fatal("insert into a missing table succeeded")
+	}
+	if session.LSN() != 0 {
+		t.Fatalf("failed insert moved the session to %s", session.LSN())
+	}
+}
+
+func TestSessionWithoutReplicas(t *testing.T) {
+	conn, err := database.Open(config.Database{
+		Driver: "sqlite",
+		Master: config.DatabaseType{Database: filepath.Join(t.TempDir(), "master.db")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	lag := &fakeLag{}
+	conn.SetLagProvider(lag)
+
+	ctx := database.WithSession(context.Background(), database.NewSession(0))
+	if _, err := conn.Exec(ctx, "CREATE TABLE t (id INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	if lag.calls.Load() != 0 {
+		t.Fatalf("write without replicas read the master position %d times", lag.calls.Load())
+	}
+}
@@ -5,8 +5,8 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/greatfocus/gf-sframe/config"
@@ -14,83 +14,202 @@ import (
 
 // Conn struct
 type Conn struct {
-	master *db
-	slave  *db
+	master   *db
+	replicas []*db
+	balancer string
+	next     atomic.Uint64
+	stop     chan struct{}
+	closed   sync.Once
+	closeErr error
+	lag      LagProvider
+	queries  *queryLog
+	retries  int
+	tenancy  *Tenancy
 }
 
 // db struct
 type db struct {
-	conn    *sql.DB
-	timeout int64
+	name     string
+	dialect  Dialect
+	dsn      string
+	conn     *sql.DB
+	timeout  int64
+	healthy  atomic.Bool
+	replayed atomic.Uint64
+	queries  *queryLog
 }
 
-// Init database connection for Master and Slave
+// Init database connection for Master and read replicas
 func (c *Conn) Init(config *config.Config, impl *config.Impl) {
-	var master = db{}
-	master.connect(config.Database.Master, impl)
-	var slave = db{}
-	slave.connect(config.Database.Master, impl)
+	if err := c.open(config.Database); err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	if config.Tenancy.Enabled {
+		err := c.SetTenancy(&Tenancy{
+			Mode:         config.Tenancy.Mode,
+			Variable:     config.Tenancy.Variable,
+			SchemaPrefix: config.Tenancy.SchemaPrefix,
+		})
+		if err != nil {
+			log.Fatal(fmt.Println(err))
+		}
+	}
+
+	// execute database migrations on the master
+	if config.Database.Master.ExecuteSchema {
+		c.migrate(config.Database.Master, impl)
+		if impl.TenantMigrations != nil && len(config.Tenancy.Tenants) > 0 {
+			migrations, err := LoadMigrations(impl.TenantMigrations)
+			if err != nil {
+				log.Fatal(fmt.Println(err))
+			}
+			c.migrateTenants(config.Tenancy.Tenants, migrations, config.Database.Master.SchemaLeaderOnly)
+		}
+	}
+	if config.Database.Master.RebuildIndexes {
+		c.master.RebuildIndexes(c.master.conn, config.Database.Master.Database)
+	}
+}
+
+// Open connects to the master and read replicas of dbConfig without running
+// migrations, such as for tests against an in-memory SQLite database
+func Open(dbConfig config.Database) (*Conn, error) {
+	c := &Conn{}
+	if err := c.open(dbConfig); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// open connects to the master and read replicas
+func (c *Conn) open(dbConfig config.Database) error {
+	dialect, err := LookupDialect(dbConfig.Driver)
+	if err != nil {
+		return err
+	}
+	c.queries = newQueryLog(dbConfig.SlowQueryThreshold.Duration())
+	c.retries = max(int(dbConfig.ReadRetries), 0)
+	var master = db{name: "master", dialect: dialect, queries: c.queries}
+	if err := master.connect(dbConfig.Master); err != nil {
+		return err
+	}
 	c.master = &master
-	c.slave = &slave
+
+	// wait for the master to accept connections before serving
+	startupTimeout := dbConfig.StartupTimeout.Duration()
+	if startupTimeout <= 0 {
+		startupTimeout = defaultStartupTimeout
+	}
+	if err := master.waitReady(startupTimeout); err != nil {
+		_ = master.conn.Close()
+		return err
+	}
+
+	for i, replicaConfig := range dbConfig.ReadReplicas() {
+		var replica = db{name: fmt.Sprintf("replica-%d", i), dialect: dialect, queries: c.queries}
+		if err := replica.connect(replicaConfig); err != nil {
+			// the connections opened so far are not handed to the caller
+			_ = master.conn.Close()
+			for _, opened := range c.replicas {
+				_ = opened.conn.Close()
+			}
+			c.replicas = nil
+			return err
+		}
+
+		// unreachable replicas join the rotation once the health check passes
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := replica.conn.PingContext(ctx)
+		cancel()
+		if err != nil {
+			log.Println("Database replica out of rotation:", replica.name, err)
+		}
+		replica.healthy.Store(err == nil)
+		c.replicas = append(c.replicas, &replica)
+	}
+	c.balancer = dbConfig.Balancer
+	c.lag = pgLagProvider{conn: c}
+	c.stop = make(chan struct{})
+	go c.checkReplicas(dbConfig.HealthCheckInterval.Duration())
+	return nil
+}
+
+// migrate applies the pending migrations of the impl
+func (c *Conn) migrate(dbConfig config.DatabaseType, impl *config.Impl) {
+	log.Println("Preparing to execute database migrations")
+	migrations, err := ParseMigrations(impl.Scripts)
+	if err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	if impl.Migrations != nil {
+		loaded, err := LoadMigrations(impl.Migrations)
+		if err != nil {
+			log.Fatal(fmt.Println(err))
+		}
+		migrations = append(migrations, loaded...)
+	}
+
+	migrator, err := NewMigrator(c, migrations)
+	if err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	migrator.DryRun = dbConfig.SchemaDryRun
+	migrator.LeaderOnly = dbConfig.SchemaLeaderOnly
+	pending, err := applyMigrations(migrator)
+	if err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	if migrator.DryRun {
+		for _, migration := range pending {
+			log.Println("Pending migration:", migration.Version, migration.Name)
+		}
+		return
+	}
+	log.Println("Database migrations successfully executed:", len(pending))
+}
+
+// applyMigrations applies the migrations, leader only migrators wait for the
+// instance applying them so that no instance serves an older schema
+func applyMigrations(migrator *Migrator) ([]Migration, error) {
+	if migrator.LeaderOnly {
+		return migrator.Await(context.Background())
+	}
+	return migrator.Up(context.Background())
 }
 
 // Connect method make a database connection
-func (d *db) connect(dbConfig config.DatabaseType, impl *config.Impl) {
+func (d *db) connect(dbConfig config.DatabaseType) error {
 	// initialize variables rom config
 	log.Println("Preparing Database configuration")
-	host := dbConfig.Host
-	database := dbConfig.Database
-	user := dbConfig.User
-	password := dbConfig.Password
-	sslmode := "disable"
-	cert := os.Args[8]
-	key := os.Args[9]
-	if dbConfig.Secure.SslMode {
-		sslmode = "require"
-	}
-	port, err := strconv.ParseUint(dbConfig.Port, 0, 64)
+	dsn, err := d.dialect.DSN(dbConfig)
 	if err != nil {
-		log.Fatal(fmt.Println(err))
+		return err
 	}
 	maxLifetime := time.Duration(dbConfig.MaxLifetime) * time.Minute
 	maxIdleConns := int(dbConfig.MaxIdleConns)
 	maxOpenConns := int(dbConfig.MaxOpenConns)
+	d.timeout = dbConfig.Timeout
 
 	// create database connection
-	psqlInfo := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s sslcert=%s sslkey=%s",
-		host, port, user, password, database, sslmode, cert, key)
-	conn, err := sql.Open("postgres", psqlInfo)
+	conn, err := sql.Open(d.dialect.Name(), dsn)
 	if err != nil {
-		log.Fatal(fmt.Println(err))
+		return err
 	}
 	conn.SetConnMaxLifetime(maxLifetime)
-	conn.SetMaxIdleConns(maxIdleConns)
+	if maxIdleConns > 0 {
+		conn.SetMaxIdleConns(maxIdleConns)
+	}
 	conn.SetMaxOpenConns(maxOpenConns)
-	log.Println("Initiating Database connection")
-
-	// execute database scripts
-	if dbConfig.ExecuteSchema {
-		d.executeSchema(conn, impl.Scripts)
-		d.RebuildIndexes(conn, dbConfig.Database)
+	if d.dialect == SQLite && isMemory(dbConfig) {
+		// an in-memory database lives as long as its single connection
+		conn.SetConnMaxLifetime(0)
+		conn.SetMaxIdleConns(1)
+		conn.SetMaxOpenConns(1)
 	}
+	log.Println("Initiating Database connection")
+	d.dsn = dsn
 	d.conn = conn
-}
-
-// ExecuteSchema prepare and execute database changes
-func (d *db) executeSchema(db *sql.DB, scripts map[string]string) {
-	// read the scripts in the folder
-	log.Println("Preparing to execute database schema")
-	// loop thru files to create schemas
-	for key, script := range scripts {
-		sql := string(script)
-		log.Println("Executing schema: ", key)
-		if _, err := db.Exec(sql); err != nil {
-			log.Fatal(fmt.Println(err))
-		}
-	}
-
-	log.Println("Database scripts successfully executed")
+	return nil
 }
 
 // RebuildIndexes within sframe
@@ -98,45 +217,187 @@ func (d *db) RebuildIndexes(db *sql.DB, dbname string) {
 	log.Println("Rebuild Indexes")
 
 	// Rebuild Indexes
-	sqlReindexScript := string("REINDEX DATABASE " + dbname + ";")
-	if _, err := db.Exec(sqlReindexScript); err != nil {
+	if _, err := db.Exec(d.dialect.Reindex(dbname)); err != nil {
 		log.Fatal(fmt.Println(err))
 	}
 
 	log.Println("Rebuild Indexes successfully executed")
 }
 
-// Insert method make a single row query to the slave databases
-func (c *Conn) Insert(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.master.timeout)*time.Second)
-	defer cancel()
-	return c.master.conn.QueryRowContext(ctx, query, args...)
+// Insert method make a single row query to the master database
+func (c *Conn) Insert(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, call := c.master.start(ctx, "insert", query, args)
+	ctx, cancel := c.master.withTimeout(ctx)
+	q, end, err := c.scope(ctx, c.master)
+	if err != nil {
+		call.end(err)
+		return &Row{release: release(cancel, nil, nil), err: c.master.wrap("insert", err)}
+	}
+	row := q.QueryRowContext(ctx, query, args...)
+	call.end(row.Err())
+	// the write is recorded once the row is scanned and its scope committed
+	record := func() { c.recordWrite(ctx) }
+	return &Row{row: row, release: release(cancel, end, record), err: c.master.wrap("insert", row.Err())}
 }
 
-// Query method make a resultset rows query to the slave databases
-func (c *Conn) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.slave.timeout)*time.Second)
-	defer cancel()
-	return c.slave.conn.QueryContext(ctx, query, args...)
+// Query method make a resultset rows query to a read replica, transient
+// failures are retried so query must only read
+func (c *Conn) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	var result *Rows
+	err := c.retryRead(ctx, "query", func(reader *db) error {
+		ctx, call := reader.start(ctx, "query", query, args)
+		ctx, cancel := reader.withTimeout(ctx)
+		q, end, err := c.scope(ctx, reader)
+		if err != nil {
+			call.end(err)
+			cancel()
+			return err
+		}
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			call.end(err)
+			_ = end(err)
+			cancel()
+			return err
+		}
+		result = &Rows{Rows: rows, release: release(cancel, end, nil), call: call}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-// Select method make a single row query to the slave databases
-func (c *Conn) Select(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.slave.timeout)*time.Second)
-	defer cancel()
-	return c.slave.conn.QueryRowContext(ctx, query, args...)
+// Select method make a single row query to a read replica, transient
+// failures are retried so query must only read
+func (c *Conn) Select(ctx context.Context, query string, args ...interface{}) *Row {
+	var result *Row
+	err := c.retryRead(ctx, "select", func(reader *db) error {
+		ctx, call := reader.start(ctx, "select", query, args)
+		ctx, cancel := reader.withTimeout(ctx)
+		q, end, err := c.scope(ctx, reader)
+		if err != nil {
+			call.end(err)
+			cancel()
+			result = &Row{release: release(cancel, nil, nil)}
+			return err
+		}
+		row := q.QueryRowContext(ctx, query, args...)
+		call.end(row.Err())
+		result = &Row{row: row, release: release(cancel, end, nil)}
+		if err := row.Err(); err != nil {
+			// release a failed attempt before it is retried
+			_ = result.release(err)
+		}
+		return row.Err()
+	})
+	result.err = err
+	return result
+}
+
+// Write method runs a statement returning rows, such as INSERT ... RETURNING,
+// on the master database
+func (c *Conn) Write(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, call := c.master.start(ctx, "write", query, args)
+	ctx, cancel := c.master.withTimeout(ctx)
+	q, end, err := c.scope(ctx, c.master)
+	if err != nil {
+		call.end(err)
+		cancel()
+		return nil, c.master.wrap("write", err)
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		call.end(err)
+		_ = end(err)
+		cancel()
+		return nil, c.master.wrap("write", err)
+	}
+	// the write is recorded once the rows are done and their scope committed
+	record := func() { c.recordWrite(ctx) }
+	return &Rows{Rows: rows, release: release(cancel, end, record), call: call}, nil
+}
+
+// Exec method executes a statement on the master database
+func (c *Conn) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.exec(ctx, "exec", query, args...)
 }
 
 // Update method executes update database changes to the master databases
 func (c *Conn) Update(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.master.timeout)*time.Second)
-	defer cancel()
-	return c.master.conn.ExecContext(ctx, query, args...)
+	return c.exec(ctx, "update", query, args...)
 }
 
 // Delete method executes delete database changes to the master databases
 func (c *Conn) Delete(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.master.timeout)*time.Second)
+	return c.exec(ctx, "delete", query, args...)
+}
+
+// exec executes a statement on the master database
+func (c *Conn) exec(ctx context.Context, operation, query string, args ...interface{}) (sql.Result, error) {
+	ctx, call := c.master.start(ctx, operation, query, args)
+	ctx, cancel := c.master.withTimeout(ctx)
 	defer cancel()
-	return c.master.conn.ExecContext(ctx, query, args...)
+	q, end, err := c.scope(ctx, c.master)
+	if err != nil {
+		call.end(err)
+		return nil, c.master.wrap(operation, err)
+	}
+	result, err := q.ExecContext(ctx, query, args...)
+	if endErr := end(err); err == nil {
+		err = endErr
+	}
+	call.end(err)
+	if err != nil {
+		return nil, c.master.wrap(operation, err)
+	}
+	c.recordWrite(ctx)
+	return result, nil
+}
+
+// Stats returns the connection pool statistics of the master and read replicas
+func (c *Conn) Stats() map[string]sql.DBStats {
+	stats := map[string]sql.DBStats{c.master.name: c.master.conn.Stats()}
+	for _, replica := range c.replicas {
+		stats[replica.name] = replica.conn.Stats()
+	}
+	return stats
+}
+
+// Names returns the names of the master and read replicas
+func (c *Conn) Names() []string {
+	names := []string{c.master.name}
+	for _, replica := range c.replicas {
+		names = append(names, replica.name)
+	}
+	return names
+}
+
+// Ping verifies the named database is reachable
+func (c *Conn) Ping(ctx context.Context, name string) error {
+	if name == c.master.name {
+		return c.master.conn.PingContext(ctx)
+	}
+	for _, replica := range c.replicas {
+		if name == replica.name {
+			return replica.conn.PingContext(ctx)
+		}
+	}
+	return fmt.Errorf("unknown database %q", name)
+}
+
+// Close stops the replica health checks and closes every database, later
+// calls return the result of the first
+func (c *Conn) Close() error {
+	c.closed.Do(func() {
+		close(c.stop)
+		c.closeErr = c.master.conn.Close()
+		for _, replica := range c.replicas {
+			if err := replica.conn.Close(); err != nil && c.closeErr == nil {
+				c.closeErr = err
+			}
+		}
+	})
+	return c.closeErr
 }
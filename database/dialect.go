@@ -0,0 +1,209 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/greatfocus/gf-sframe/config"
+)
+
+// ErrUnsupported is returned by features the database driver lacks, such
+// as LISTEN/NOTIFY on SQLite
+var ErrUnsupported = errors.New("not supported by the database driver")
+
+// Dialect describes the SQL of a database driver
+type Dialect interface {
+	// Name returns the database/sql driver name
+	Name() string
+	// System returns the db.system attribute of traces
+	System() string
+	// DSN returns the data source name of a database configuration
+	DSN(dbConfig config.DatabaseType) (string, error)
+	// Placeholder returns the bind parameter of the nth argument, from 1
+	Placeholder(n int) string
+	// Quote quotes a possibly schema qualified identifier
+	Quote(name string) string
+	// Upsert returns the clause resolving conflicts on the conflict columns
+	// by updating columns to the inserted values, or by skipping the row
+	// without columns
+	Upsert(conflict, columns []string) string
+	// Timestamp returns the column type of a point in time
+	Timestamp() string
+	// Reindex returns the statement rebuilding the indexes of database
+	Reindex(database string) string
+}
+
+// Dialects of the supported drivers. SQLite needs its driver, imported
+// with the database/sqlite package.
+var (
+	Postgres Dialect = postgresDialect{}
+	SQLite   Dialect = sqliteDialect{}
+)
+
+// dialects holds the registered dialects by driver name
+var dialects = struct {
+	sync.RWMutex
+	byName map[string]Dialect
+}{byName: map[string]Dialect{
+	Postgres.Name(): Postgres,
+	SQLite.Name():   SQLite,
+}}
+
+// RegisterDialect makes a dialect available to the driver config
+func RegisterDialect(d Dialect) {
+	dialects.Lock()
+	defer dialects.Unlock()
+	dialects.byName[d.Name()] = d
+}
+
+// LookupDialect returns the dialect of a driver, Postgres by default
+func LookupDialect(driver string) (Dialect, error) {
+	if driver == "" {
+		return Postgres, nil
+	}
+	dialects.RLock()
+	defer dialects.RUnlock()
+	d, ok := dialects.byName[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+	return d, nil
+}
+
+// dialectOf returns the dialect of a Conn, Tx or Writer, Postgres for other
+// queriers
+func dialectOf(q interface{}) Dialect {
+	if d, ok := q.(interface{ Dialect() Dialect }); ok {
+		return d.Dialect()
+	}
+	return Postgres
+}
+
+// Dialect returns the dialect of the master database
+func (c *Conn) Dialect() Dialect {
+	return c.master.dialect
+}
+
+// onConflict writes the ON CONFLICT clause shared by Postgres and SQLite
+func onConflict(d Dialect, conflict, columns []string) string {
+	var sb strings.Builder
+	sb.WriteString("ON CONFLICT")
+	if len(conflict) > 0 {
+		quoted := make([]string, len(conflict))
+		for i, column := range conflict {
+			quoted[i] = d.Quote(column)
+		}
+		sb.WriteString(" (" + strings.Join(quoted, ", ") + ")")
+	}
+	if len(columns) == 0 {
+		sb.WriteString(" DO NOTHING")
+		return sb.String()
+	}
+	sets := make([]string, len(columns))
+	for i, column := range columns {
+		sets[i] = d.Quote(column) + " = EXCLUDED." + d.Quote(column)
+	}
+	sb.WriteString(" DO UPDATE SET " + strings.Join(sets, ", "))
+	return sb.String()
+}
+
+// postgresDialect is the dialect of lib/pq
+type postgresDialect struct{}
+
+// Name returns the driver name
+func (postgresDialect) Name() string { return "postgres" }
+
+// System returns the db.system attribute
+func (postgresDialect) System() string { return "postgresql" }
+
+// DSN returns the libpq connection string, the client certificate and key
+// are read from the command line
+func (postgresDialect) DSN(dbConfig config.DatabaseType) (string, error) {
+	port, err := strconv.ParseUint(dbConfig.Port, 0, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid database port %q: %w", dbConfig.Port, err)
+	}
+	sslmode := "disable"
+	if dbConfig.Secure.SslMode {
+		sslmode = "require"
+	}
+	var cert, key string
+	if len(os.Args) > 9 {
+		cert, key = os.Args[8], os.Args[9]
+	}
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s sslcert=%s sslkey=%s",
+		dbConfig.Host, port, dbConfig.User, dbConfig.Password, dbConfig.Database, sslmode, cert, key), nil
+}
+
+// Placeholder returns $n
+func (postgresDialect) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+
+// Quote quotes with double quotes
+func (postgresDialect) Quote(name string) string { return QuoteIdentifier(name) }
+
+// Upsert returns an ON CONFLICT clause
+func (d postgresDialect) Upsert(conflict, columns []string) string {
+	return onConflict(d, conflict, columns)
+}
+
+// Timestamp returns TIMESTAMPTZ
+func (postgresDialect) Timestamp() string { return "TIMESTAMPTZ" }
+
+// Reindex returns REINDEX DATABASE
+func (postgresDialect) Reindex(database string) string {
+	return "REINDEX DATABASE " + QuoteIdentifier(database)
+}
+
+// sqliteDialect is the dialect of the pure Go modernc.org/sqlite driver
+type sqliteDialect struct{}
+
+// Name returns the driver name
+func (sqliteDialect) Name() string { return "sqlite" }
+
+// System returns the db.system attribute
+func (sqliteDialect) System() string { return "sqlite" }
+
+// DSN returns the file of the database, :memory: or an empty name opens a
+// private in-memory database
+func (sqliteDialect) DSN(dbConfig config.DatabaseType) (string, error) {
+	pragmas := "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate"
+	if isMemory(dbConfig) {
+		return "file::memory:?" + pragmas, nil
+	}
+	return "file:" + dbConfig.Database + "?_pragma=journal_mode(WAL)&" + pragmas, nil
+}
+
+// Placeholder returns $n, which SQLite binds by position
+func (sqliteDialect) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+
+// Quote quotes with double quotes
+func (sqliteDialect) Quote(name string) string { return QuoteIdentifier(name) }
+
+// Upsert returns an ON CONFLICT clause
+func (d sqliteDialect) Upsert(conflict, columns []string) string {
+	return onConflict(d, conflict, columns)
+}
+
+// Timestamp returns TIMESTAMP, which the driver scans into time.Time
+func (sqliteDialect) Timestamp() string { return "TIMESTAMP" }
+
+// Reindex returns REINDEX, SQLite files hold a single database
+func (sqliteDialect) Reindex(database string) string { return "REINDEX" }
+
+// isMemory checks if a SQLite configuration is an in-memory database
+func isMemory(dbConfig config.DatabaseType) bool {
+	return dbConfig.Database == "" || dbConfig.Database == ":memory:"
+}
+
+// unsupported fails features limited to Postgres on other dialects
+func unsupported(d Dialect, feature string) error {
+	if d == Postgres {
+		return nil
+	}
+	return fmt.Errorf("%s on %s: %w", feature, d.Name(), ErrUnsupported)
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gfdispatcher "github.com/greatfocus/gf-dispatcher"
+	"github.com/lib/pq"
+)
+
+// Reconnect intervals and keep alive of the listener connection
+const (
+	minReconnect  = time.Second
+	maxReconnect  = time.Minute
+	listenerPing  = 90 * time.Second
+	maxNotifySize = 8000
+	// listenerQueue bounds the notifications waiting for their handlers
+	listenerQueue = 1024
+)
+
+// Notification is a payload sent with NOTIFY
+type Notification struct {
+	Channel string
+	Payload string
+	PID     int
+}
+
+// NotificationHandler handles the notifications of a channel
+type NotificationHandler func(ctx context.Context, n Notification)
+
+// Listener holds a dedicated master connection that LISTENs on channels and
+// delivers their notifications to handlers through the dispatcher. The
+// connection is opened on the first Listen and re-established when lost.
+// Notifications are queued so that slow handlers do not stall the
+// connection, they are dropped and counted once the queue is full.
+type Listener struct {
+	dialect  Dialect
+	dsn      string
+	dispatch func(job gfdispatcher.Job)
+	mu       sync.RWMutex
+	listener *pq.Listener
+	handlers map[string][]NotificationHandler
+	queue    chan Notification
+	dropped  atomic.Int64
+	stop     chan struct{}
+	closed   sync.Once
+	closeErr error
+}
+
+// NewListener creates a listener on the master database. Notifications are
+// handed to dispatch, such as server.Meta.Dispatch, or run in a goroutine
+// when dispatch is nil.
+func NewListener(c *Conn, dispatch func(job gfdispatcher.Job)) *Listener {
+	return &Listener{
+		dialect:  c.master.dialect,
+		dsn:      c.master.dsn,
+		dispatch: dispatch,
+		handlers: make(map[string][]NotificationHandler),
+		queue:    make(chan Notification, listenerQueue),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Listen subscribes to channel without a handler, its notifications are
+// logged until a handler is registered with Handle
+func (l *Listener) Listen(channel string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.listen(channel)
+}
+
+// Handle registers handler for the notifications of channel, listening on
+// the channel if needed
+func (l *Listener) Handle(channel string, handler NotificationHandler) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.listen(channel); err != nil {
+		return err
+	}
+	l.handlers[channel] = append(l.handlers[channel], handler)
+	return nil
+}
+
+// listen starts the connection if needed and listens on channel
+func (l *Listener) listen(channel string) error {
+	if err := unsupported(l.dialect, "LISTEN"); err != nil {
+		return err
+	}
+	if l.listener == nil {
+		l.listener = pq.NewListener(l.dsn, minReconnect, maxReconnect, l.event)
+		go l.run(l.listener)
+		go l.work()
+	}
+	err := l.listener.Listen(channel)
+	if errors.Is(err, pq.ErrChannelAlreadyOpen) {
+		return nil
+	}
+	return err
+}
+
+// event logs the state changes of the connection
+func (l *Listener) event(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		log.Println("Database listener disconnected", err)
+	case pq.ListenerEventReconnected:
+		log.Println("Database listener reconnected")
+	case pq.ListenerEventConnectionAttemptFailed:
+		log.Println("Database listener connection failed", err)
+	}
+}
+
+// run delivers notifications until the listener is closed
+func (l *Listener) run(listener *pq.Listener) {
+	ticker := time.NewTicker(listenerPing)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case n, ok := <-listener.NotificationChannel():
+			if !ok {
+				return
+			}
+			// a nil notification follows a reconnect, notifications sent
+			// while disconnected are lost
+			if n != nil {
+				l.enqueue(Notification{Channel: n.Channel, Payload: n.Extra, PID: n.BePid})
+			}
+		case <-ticker.C:
+			// detect a silently dropped connection
+			go func() { _ = listener.Ping() }()
+		}
+	}
+}
+
+// enqueue queues the notification without blocking the connection
+func (l *Listener) enqueue(n Notification) {
+	select {
+	case l.queue <- n:
+	default:
+		if l.dropped.Add(1)%listenerQueue == 1 {
+			log.Println("Database listener queue full, dropped notifications:", l.dropped.Load())
+		}
+	}
+}
+
+// work delivers the queued notifications until the listener is closed
+func (l *Listener) work() {
+	for {
+		select {
+		case <-l.stop:
+			return
+		case n := <-l.queue:
+			l.deliver(n)
+		}
+	}
+}
+
+// Dropped returns the number of notifications dropped on a full queue
+func (l *Listener) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// deliver hands the notification to each handler of its channel
+func (l *Listener) deliver(n Notification) {
+	l.mu.RLock()
+	handlers := l.handlers[n.Channel]
+	l.mu.RUnlock()
+	if len(handlers) == 0 {
+		log.Println("Database notification without a handler:", n.Channel)
+		return
+	}
+	for _, handler := range handlers {
+		handler := handler
+		run := func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Println("Notification handler panicked", n.Channel, r)
+				}
+			}()
+			handler(WithCaller(context.Background(), "notify "+n.Channel), n)
+		}
+		if l.dispatch == nil {
+			go run()
+			continue
+		}
+		l.dispatch(gfdispatcher.Job{Handler: func(w http.ResponseWriter, r *http.Request) { run() }})
+	}
+}
+
+// Ping verifies the listener connection, it succeeds while nothing is
+// listened on
+func (l *Listener) Ping() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.listener == nil {
+		return nil
+	}
+	return l.listener.Ping()
+}
+
+// Close stops listening and closes the connection, later calls return the
+// result of the first
+func (l *Listener) Close() error {
+	l.closed.Do(func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		close(l.stop)
+		if l.listener != nil {
+			l.closeErr = l.listener.Close()
+		}
+	})
+	return l.closeErr
+}
+
+// Notify sends payload on channel through e, a Conn or a Tx. Inside a
+// transaction the notification is sent on commit.
+func Notify(ctx context.Context, e Executor, channel, payload string) error {
+	if err := unsupported(dialectOf(e), "NOTIFY"); err != nil {
+		return err
+	}
+	if len(payload) >= maxNotifySize {
+		return errors.New("notification payload must be shorter than 8000 bytes")
+	}
+	_, err := e.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
@@ -0,0 +1,319 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrLockHeld is returned when another session holds a lock
+var ErrLockHeld = errors.New("lock is held by another session")
+
+// lockHeld checks that the session still holds an advisory lock, the key is
+// split over classid and objid by Postgres
+const lockHeld = `SELECT EXISTS (
+	SELECT 1 FROM pg_locks
+	WHERE locktype = 'advisory' AND granted AND pid = pg_backend_pid()
+	AND classid = (($1::bigint >> 32) & 4294967295)::oid
+	AND objid = ($1::bigint & 4294967295)::oid
+	AND objsubid = 1
+)`
+
+// LockKey hashes a lock name into an advisory lock key
+func LockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// Lock is a session advisory lock held on a dedicated master connection.
+// With a ttl the connection is checked every third of it and the lock is
+// reported lost once it cannot be confirmed within the ttl. Drivers without
+// advisory locks, such as SQLite, hold the lock within the process.
+type Lock struct {
+	name     string
+	key      int64
+	conn     *sql.Conn
+	release  func()
+	lost     chan struct{}
+	lostOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+	unlock   sync.Once
+}
+
+// Lock waits until the named lock is acquired or ctx is done
+func (c *Conn) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	return c.master.lock(ctx, name, LockKey(name), true, ttl)
+}
+
+// TryLock acquires the named lock, failing with ErrLockHeld when another
+// session holds it
+func (c *Conn) TryLock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	return c.master.lock(ctx, name, LockKey(name), false, ttl)
+}
+
+// lock acquires an advisory lock on a dedicated connection
+func (d *db) lock(ctx context.Context, name string, key int64, wait bool, ttl time.Duration) (*Lock, error) {
+	if d.dialect != Postgres {
+		return localLock(ctx, name, key, wait)
+	}
+	conn, err := d.conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if wait {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key)
+	} else {
+		var locked bool
+		err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+		if err == nil && !locked {
+			err = ErrLockHeld
+		}
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	l := &Lock{
+		name: name,
+		key:  key,
+		conn: conn,
+		lost: make(chan struct{}),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if ttl > 0 {
+		go l.heartbeat(ttl)
+	} else {
+		close(l.done)
+	}
+	return l, nil
+}
+
+// heartbeat confirms the lock is still held until it is unlocked
+func (l *Lock) heartbeat(ttl time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	confirmed := time.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+		var held bool
+		err := l.conn.QueryRowContext(ctx, lockHeld, l.key).Scan(&held)
+		cancel()
+		if err == nil && held {
+			confirmed = time.Now()
+			continue
+		}
+		if (err == nil && !held) || time.Since(confirmed) >= ttl {
+			log.Println("Lost lock", l.name, err)
+			l.lostOnce.Do(func() { close(l.lost) })
+			// closing the session releases the lock on the server
+			_ = l.conn.Close()
+			return
+		}
+	}
+}
+
+// Lost is closed once the lock can no longer be confirmed
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock releases the lock and its connection
+func (l *Lock) Unlock() error {
+	var err error
+	l.unlock.Do(func() {
+		close(l.stop)
+		<-l.done
+		select {
+		case <-l.lost:
+			return
+		default:
+		}
+		if l.release != nil {
+			l.release()
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err = l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key)
+		if closeErr := l.conn.Close(); err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
+
+// localLocks holds the locks of drivers without advisory locks
+var localLocks = struct {
+	sync.Mutex
+	held map[int64]chan struct{}
+}{held: make(map[int64]chan struct{})}
+
+// localLock acquires a lock within the process, which is never lost
+func localLock(ctx context.Context, name string, key int64, wait bool) (*Lock, error) {
+	for {
+		localLocks.Lock()
+		released, held := localLocks.held[key]
+		if !held {
+			released = make(chan struct{})
+			localLocks.held[key] = released
+		}
+		localLocks.Unlock()
+
+		if !held {
+			l := &Lock{
+				name: name,
+				key:  key,
+				lost: make(chan struct{}),
+				stop: make(chan struct{}),
+				done: make(chan struct{}),
+				release: func() {
+					localLocks.Lock()
+					delete(localLocks.held, key)
+					localLocks.Unlock()
+					close(released)
+				},
+			}
+			close(l.done)
+			return l, nil
+		}
+		if !wait {
+			return nil, ErrLockHeld
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// TryXactLock acquires the named lock for the rest of tx, it returns false
+// when another session holds it. SQLite serializes write transactions, so
+// the lock is always granted there.
+func TryXactLock(ctx context.Context, tx Tx, name string) (bool, error) {
+	if tx.Dialect() != Postgres {
+		return true, nil
+	}
+	var locked bool
+	err := tx.Select(ctx, "SELECT pg_try_advisory_xact_lock($1)", LockKey(name)).Scan(&locked)
+	return locked, err
+}
+
+// XactLock waits for the named lock and holds it for the rest of tx
+func XactLock(ctx context.Context, tx Tx, name string) error {
+	if tx.Dialect() != Postgres {
+		return nil
+	}
+	_, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", LockKey(name))
+	return err
+}
+
+// Elector elects a single leader among the instances sharing a name by
+// holding a session lock, the next instance takes over once it is lost
+type Elector struct {
+	conn   *Conn
+	name   string
+	ttl    time.Duration
+	mu     sync.RWMutex
+	lock   *Lock
+	stop   chan struct{}
+	done   chan struct{}
+	closer sync.Once
+}
+
+// NewElector creates an elector for name, call Start to take part
+func NewElector(c *Conn, name string, ttl time.Duration) *Elector {
+	return &Elector{
+		conn: c,
+		name: name,
+		ttl:  ttl,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Start campaigns for leadership in the background
+func (e *Elector) Start() {
+	go e.run()
+}
+
+// run tries to acquire the lock every third of the ttl while not leading
+func (e *Elector) run() {
+	defer close(e.done)
+	interval := max(e.ttl/3, time.Second)
+	for {
+		e.mu.RLock()
+		lock := e.lock
+		e.mu.RUnlock()
+
+		if lock == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			acquired, err := e.conn.TryLock(ctx, e.name, e.ttl)
+			cancel()
+			switch {
+			case err == nil:
+				log.Println("Elected leader", e.name)
+				e.mu.Lock()
+				e.lock = acquired
+				e.mu.Unlock()
+				continue
+			case !errors.Is(err, ErrLockHeld):
+				log.Println("Leader election failed", e.name, err)
+			}
+			select {
+			case <-e.stop:
+				return
+			case <-time.After(interval):
+			}
+			continue
+		}
+
+		select {
+		case <-e.stop:
+			return
+		case <-lock.Lost():
+			log.Println("Lost leadership", e.name)
+			e.mu.Lock()
+			e.lock = nil
+			e.mu.Unlock()
+		}
+	}
+}
+
+// IsLeader checks if this instance currently leads
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.lock != nil
+}
+
+// Stop gives up leadership and stops campaigning
+func (e *Elector) Stop() error {
+	var err error
+	e.closer.Do(func() {
+		close(e.stop)
+		<-e.done
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.lock != nil {
+			err = e.lock.Unlock()
+			e.lock = nil
+		}
+	})
+	return err
+}
@@ -0,0 +1,458 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationLock is the advisory lock key held while migrating
+const migrationLock int64 = 0x67665f6d696772 // "gf_migr"
+
+// migrationPoll is the interval of Await checking the migration lock
+const migrationPoll = time.Second
+
+// ErrChecksumMismatch is returned when an applied migration was edited
+var ErrChecksumMismatch = errors.New("applied migration has been modified")
+
+// Migration struct
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Baseline marks a script named without a version, as executeSchema
+	// ran them. Baseline migrations run first, in name order, and are
+	// applied again when they change instead of failing the checksum.
+	Baseline bool
+}
+
+// Checksum returns the sha256 of the up script
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationStatus struct
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	Modified  bool       `json:"modified"`
+}
+
+// Migrator struct
+type Migrator struct {
+	master     *db
+	migrations []Migration
+	// DryRun reports pending migrations without applying them
+	DryRun bool
+	// LeaderOnly makes Up fail with ErrLockHeld while another instance
+	// migrates instead of waiting for the lock, see Await
+	LeaderOnly bool
+	// Table tracks the applied migrations, schema_migrations by default.
+	// Components of the frame track theirs apart from the service.
+	Table   string
+	tenant  string
+	tenancy *Tenancy
+}
+
+// NewMigrator creates a migrator for the master database
+func NewMigrator(c *Conn, migrations []Migration) (*Migrator, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return migrationLess(sorted[i], sorted[j]) })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", sorted[i].Version)
+		}
+	}
+	return &Migrator{master: c.master, migrations: sorted}, nil
+}
+
+// LoadMigrations reads <version>_<name>.up.sql and <version>_<name>.down.sql
+// files from fsys, such as an embed.FS
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	scripts := make(map[string]string, len(files))
+	for _, file := range files {
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		scripts[file] = string(body)
+	}
+	return ParseMigrations(scripts)
+}
+
+// ParseMigrations converts named scripts into migrations. Names start with
+// a numeric version such as 0001_create_users.sql; a .down.sql suffix marks
+// the rollback script of the version. Names without a version, such as the
+// Impl.Scripts keys of executeSchema, are baseline migrations.
+func ParseMigrations(scripts map[string]string) ([]Migration, error) {
+	byVersion := make(map[int64]*Migration)
+	for file, script := range scripts {
+		name := strings.TrimSuffix(path.Base(file), ".sql")
+		down := strings.HasSuffix(name, ".down")
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".down"), ".up")
+
+		prefix, rest, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		baseline := err != nil || version < 0
+		if baseline {
+			version, rest = baselineVersion(name), name
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Baseline: baseline}
+			byVersion[version] = m
+		}
+		if rest != "" {
+			m.Name = rest
+		}
+		if down {
+			m.Down = script
+		} else {
+			if m.Up != "" {
+				return nil, fmt.Errorf("duplicate migration version %d", version)
+			}
+			m.Up = script
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrationLess(migrations[i], migrations[j]) })
+	return migrations, nil
+}
+
+// baselineVersion returns the version of a baseline migration, a negative
+// hash of its name that stays the same as scripts are added
+func baselineVersion(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return -int64(h.Sum64()>>1) - 1
+}
+
+// migrationLess orders the baseline migrations by name before the others
+// by version
+func migrationLess(a, b Migration) bool {
+	if a.Baseline != b.Baseline {
+		return a.Baseline
+	}
+	if a.Baseline {
+		return a.Name < b.Name
+	}
+	return a.Version < b.Version
+}
+
+// Status reports which migrations have been applied, without creating the
+// schema_migrations table when it does not exist yet
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	exists, err := m.tableExists(ctx, m.master.conn)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]appliedMigration)
+	if exists {
+		if applied, err = m.applied(ctx, m.master.conn); err != nil {
+			return nil, err
+		}
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		s := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if a, ok := applied[migration.Version]; ok {
+			appliedAt := a.appliedAt
+			s.Applied = true
+			s.AppliedAt = &appliedAt
+			s.Modified = a.checksum != migration.Checksum()
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Up applies every pending migration in version order and returns them
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.up(ctx, !m.LeaderOnly)
+}
+
+// Await applies the pending migrations like Up, or waits for the instance
+// holding the migration lock to apply them without queuing on the lock. It
+// takes over when that instance stops before the migrations are applied.
+func (m *Migrator) Await(ctx context.Context) ([]Migration, error) {
+	waiting := false
+	for {
+		pending, err := m.up(ctx, false)
+		if !errors.Is(err, ErrLockHeld) || m.DryRun {
+			return pending, err
+		}
+		applied, err := m.current(ctx)
+		if err != nil || applied {
+			return nil, err
+		}
+		if !waiting {
+			log.Println("Waiting for the migrations of another instance")
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(migrationPoll):
+		}
+	}
+}
+
+// current checks if every migration is applied as it is
+func (m *Migrator) current(ctx context.Context) (bool, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range status {
+		if !s.Applied || s.Modified {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// up applies the pending migrations, waiting for the lock if wait is set
+func (m *Migrator) up(ctx context.Context, wait bool) ([]Migration, error) {
+	var pending []Migration
+	err := m.locked(ctx, wait, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		changed := make(map[int64]bool)
+		for _, migration := range m.migrations {
+			a, ok := applied[migration.Version]
+			switch {
+			case !ok:
+				pending = append(pending, migration)
+			case a.checksum == migration.Checksum():
+			case migration.Baseline:
+				// baseline scripts were written to run on every start
+				pending = append(pending, migration)
+				changed[migration.Version] = true
+			default:
+				return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, migration.Version, migration.Name)
+			}
+		}
+		if m.DryRun {
+			return nil
+		}
+
+		table := m.table()
+		for _, migration := range pending {
+			log.Println("Executing migration:", migration.Version, migration.Name)
+			record := "INSERT INTO " + table + " (version, name, checksum) VALUES ($1, $2, $3)"
+			if changed[migration.Version] {
+				record = "UPDATE " + table + " SET name = $2, checksum = $3, applied_at = CURRENT_TIMESTAMP WHERE version = $1"
+			}
+			err := m.exec(ctx, conn, migration.Up, record,
+				migration.Version, migration.Name, migration.Checksum())
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	})
+	return pending, err
+}
+
+// Down rolls back the last steps applied migrations and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var rollback []Migration
+	err := m.locked(ctx, !m.LeaderOnly, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for k := len(m.migrations) - 1; k >= 0 && len(rollback) < steps; k-- {
+			if _, ok := applied[m.migrations[k].Version]; ok {
+				rollback = append(rollback, m.migrations[k])
+			}
+		}
+		if m.DryRun {
+			return nil
+		}
+
+		for _, migration := range rollback {
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", migration.Version, migration.Name)
+			}
+			log.Println("Rolling back migration:", migration.Version, migration.Name)
+			err := m.exec(ctx, conn, migration.Down,
+				"DELETE FROM "+m.table()+" WHERE version = $1", migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	})
+	return rollback, err
+}
+
+// execer is satisfied by sql.DB and sql.Conn
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// appliedMigration struct
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// table returns the quoted migrations table, in the schema of the tenant of
+// tenant migrators
+func (m *Migrator) table() string {
+	if m.tenant != "" {
+		return QuoteIdentifier(m.tenancy.Schema(m.tenant) + "." + m.tableName())
+	}
+	return QuoteIdentifier(m.tableName())
+}
+
+// tableName returns the name of the migrations table
+func (m *Migrator) tableName() string {
+	if m.Table != "" {
+		return m.Table
+	}
+	return "schema_migrations"
+}
+
+// ensureTable creates the schema_migrations table
+func (m *Migrator) ensureTable(ctx context.Context, conn execer) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at %s NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`, m.table(), m.master.dialect.Timestamp()))
+	return err
+}
+
+// tableExists checks if the schema_migrations table has been created
+func (m *Migrator) tableExists(ctx context.Context, conn execer) (bool, error) {
+	query, arg := "SELECT to_regclass($1) IS NOT NULL", m.table()
+	if m.master.dialect == SQLite {
+		query, arg = "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = $1", m.tableName()
+	}
+	rows, err := conn.QueryContext(ctx, query, arg)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	var exists bool
+	if rows.Next() {
+		if err := rows.Scan(&exists); err != nil {
+			return false, err
+		}
+	}
+	return exists, rows.Err()
+}
+
+// applied returns the applied migrations by version
+func (m *Migrator) applied(ctx context.Context, conn execer) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, checksum, applied_at FROM "+m.table())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var version int64
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// locked runs fn on a connection holding the migration advisory lock, so
+// only one replica migrates at a time. It fails with ErrLockHeld when the
+// lock is taken and wait is not set.
+func (m *Migrator) locked(ctx context.Context, wait bool, fn func(conn *sql.Conn) error) error {
+	name, key := "schema-migrations", migrationLock
+	if m.Table != "" {
+		name, key = m.Table, LockKey(m.Table)
+	}
+	if m.tenant != "" {
+		name += ":" + m.tenant
+		key = LockKey(name)
+	}
+	lock, err := m.master.lock(ctx, name, key, wait, 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	conn := lock.conn
+	if conn == nil {
+		// locks held in process come without a connection
+		if conn, err = m.master.conn.Conn(ctx); err != nil {
+			return err
+		}
+		defer conn.Close()
+	}
+	if m.tenant != "" {
+		// the tenant schema holds its own schema_migrations table
+		schema := QuoteIdentifier(m.tenancy.Schema(m.tenant))
+		if _, err = conn.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+schema); err != nil {
+			return err
+		}
+		if err = m.tenancy.apply(ctx, conn, m.tenant, false); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), "RESET search_path")
+	}
+	if err = m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// exec runs a migration script and its bookkeeping in one transaction
+func (m *Migrator) exec(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
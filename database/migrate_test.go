@@ -0,0 +1,145 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/greatfocus/gf-sframe/database"
+	"github.com/greatfocus/gf-sframe/database/sqlite"
+)
+
+// memory opens an in-memory database closed with the test
+func memory(t *testing.T) *database.Conn {
+	t.Helper()
+	conn, err := sqlite.Memory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// applied returns the applied versions reported by m
+func applied(t *testing.T, m *database.Migrator) []int64 {
+	t.Helper()
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int64
+	for _, s := range status {
+		if s.Applied {
+			versions = append(versions, s.Version)
+		}
+	}
+	return versions
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	conn := memory(t)
+	ctx := context.Background()
+	migrations, err := database.ParseMigrations(map[string]string{
+		"0001_create_users.up.sql":   "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL)",
+		"0001_create_users.down.sql": "DROP TABLE users",
+		"0002_add_email.up.sql":      "ALTER TABLE users ADD COLUMN email TEXT",
+		"0002_add_email.down.sql":    "ALTER TABLE users DROP COLUMN email",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := database.NewMigrator(conn, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := applied(t, m); len(got) != 0 {
+		t.Fatalf("applied before Up: %v", got)
+	}
+	pending, err := m.Up(ctx)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("first Up applied %d migrations: %v", len(pending), err)
+	}
+	if _, err := conn.Exec(ctx, "INSERT INTO users (name, email) VALUES ($1, $2)", "ann", "ann@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err = m.Up(ctx); err != nil || len(pending) != 0 {
+		t.Fatalf("second Up applied %d migrations: %v", len(pending), err)
+	}
+
+	rollback, err := m.Down(ctx, 1)
+	if err != nil || len(rollback) != 1 || rollback[0].Version != 2 {
+		t.Fatalf("Down rolled back %v: %v", rollback, err)
+	}
+	if got := applied(t, m); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("applied after Down: %v", got)
+	}
+	if _, err := conn.Exec(ctx, "INSERT INTO users (name, email) VALUES ($1, $2)", "bob", "bob@example.com"); err == nil {
+		t.Fatal("email column kept after Down")
+	}
+}
+
+func TestMigratorChecksum(t *testing.T) {
+	conn := memory(t)
+	ctx := context.Background()
+	m, err := database.NewMigrator(conn, []database.Migration{
+		{Version: 1, Name: "create_users", Up: "CREATE TABLE users (id INTEGER PRIMARY KEY)"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	edited, err := database.NewMigrator(conn, []database.Migration{
+		{Version: 1, Name: "create_users", Up: "CREATE TABLE users (id BIGINT PRIMARY KEY)"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := edited.Up(ctx); !errors.Is(err, database.ErrChecksumMismatch) {
+		t.Fatalf("edited migration applied with %v", err)
+	}
+}
+
+func TestMigratorBaseline(t *testing.T) {
+	conn := memory(t)
+	ctx := context.Background()
+	scripts := map[string]string{
+		"schema":                "CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY)",
+		"0001_add_name.up.sql":  "ALTER TABLE users ADD COLUMN name TEXT",
+		"0002_add_email.up.sql": "ALTER TABLE users ADD COLUMN email TEXT",
+	}
+	up := func() []database.Migration {
+		t.Helper()
+		migrations, err := database.ParseMigrations(scripts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := database.NewMigrator(conn, migrations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, err := m.Up(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pending
+	}
+
+	pending := up()
+	if len(pending) != 3 || !pending[0].Baseline || pending[0].Name != "schema" {
+		t.Fatalf("baseline not applied first: %v", pending)
+	}
+	if pending = up(); len(pending) != 0 {
+		t.Fatalf("unchanged baseline applied again: %v", pending)
+	}
+	scripts["schema"] += ";\nCREATE TABLE IF NOT EXISTS roles (id INTEGER PRIMARY KEY)"
+	if pending = up(); len(pending) != 1 || pending[0].Name != "schema" {
+		t.Fatalf("changed baseline not applied again: %v", pending)
+	}
+	if _, err := conn.Exec(ctx, "INSERT INTO roles (id) VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Balancers choose the read replica of a query
+const (
+	RoundRobin       = "round-robin"
+	LeastConnections = "least-connections"
+)
+
+// defaultHealthCheckInterval is used when no interval is configured
+const defaultHealthCheckInterval = 10 * time.Second
+
+// primaryKey is the context key forcing reads to the master
+type primaryKey struct{}
+
+// WithPrimary returns a context whose reads go to the master database
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// usePrimary checks if reads in ctx must go to the master database
+func usePrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// reader returns the database to read from, falling back to the master
+// when the context asks for it, no replica is healthy or no replica has
+// caught up with the last write of the session
+func (c *Conn) reader(ctx context.Context) *db {
+	if usePrimary(ctx) {
+		return c.master
+	}
+	healthy := c.healthyReplicas()
+	if session, ok := SessionFromContext(ctx); ok && session.LSN() > 0 {
+		lsn := session.LSN()
+		caughtUp := healthy[:0]
+		for _, replica := range healthy {
+			if c.caughtUp(ctx, replica, lsn) {
+				caughtUp = append(caughtUp, replica)
+			}
+		}
+		healthy = caughtUp
+	}
+	if len(healthy) == 0 {
+		return c.master
+	}
+
+	switch c.balancer {
+	case LeastConnections:
+		least := healthy[0]
+		for _, replica := range healthy[1:] {
+			if replica.conn.Stats().InUse < least.conn.Stats().InUse {
+				least = replica
+			}
+		}
+		return least
+	default:
+		return healthy[c.next.Add(1)%uint64(len(healthy))]
+	}
+}
+
+// healthyReplicas returns the replicas in rotation
+func (c *Conn) healthyReplicas() []*db {
+	healthy := make([]*db, 0, len(c.replicas))
+	for _, replica := range c.replicas {
+		if replica.healthy.Load() {
+			healthy = append(healthy, replica)
+		}
+	}
+	return healthy
+}
+
+// checkReplicas pings the replicas periodically and takes unhealthy ones
+// out of rotation until they recover
+func (c *Conn) checkReplicas(interval time.Duration) {
+	if len(c.replicas) == 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			for _, replica := range c.replicas {
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				err := replica.conn.PingContext(ctx)
+				cancel()
+
+				healthy := err == nil
+				if replica.healthy.Swap(healthy) != healthy {
+					if healthy {
+						log.Println("Database replica back in rotation:", replica.name)
+					} else {
+						log.Println("Database replica out of rotation:", replica.name, err)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,685 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Default and maximum page sizes of Repository.List
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// ErrStaleVersion is returned when an update lost an optimistic lock
+var ErrStaleVersion = errors.New("record was modified by another request")
+
+// Executor runs queries, it is satisfied by Conn and Tx
+type Executor interface {
+	Querier
+	Insert(ctx context.Context, query string, args ...interface{}) *Row
+	Select(ctx context.Context, query string, args ...interface{}) *Row
+	Update(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Delete(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Write(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+}
+
+// Writer returns a Querier running its queries through e.Write, so that
+// statements such as INSERT ... RETURNING can be scanned with QueryStructs
+func Writer(e Executor) Querier {
+	return writer{e}
+}
+
+// writer struct
+type writer struct {
+	e Executor
+}
+
+// Query runs the query as a write
+func (w writer) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return w.e.Write(ctx, query, args...)
+}
+
+// Dialect returns the dialect of the executor
+func (w writer) Dialect() Dialect {
+	return dialectOf(w.e)
+}
+
+// column struct
+type column struct {
+	name      string
+	index     []int
+	pk        bool
+	version   bool
+	omitempty bool
+	readonly  bool
+}
+
+// Repository provides CRUD for a table mapped to T through db tags such as
+// `db:"id,pk"`, `db:"version,version"` or `db:"created_at,readonly"`. A
+// deleted_at column turns Delete into a soft delete.
+type Repository[T any] struct {
+	conn       *Conn
+	table      string
+	columns    []column
+	pk         *column
+	version    *column
+	softDelete *column
+	filters    map[string]string
+	sorts      map[string]string
+	hooks      []ChangeHook
+}
+
+// Actions of a Change
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change describes a write of a repository record, Before is nil on create
+// and After is nil on delete
+type Change struct {
+	Table  string
+	Action string
+	ID     interface{}
+	Before interface{}
+	After  interface{}
+}
+
+// ChangeHook is called in the transaction of a repository write, such as
+// to record an audit trail. An error rolls the write back.
+type ChangeHook func(ctx context.Context, change Change) error
+
+// NewRepository creates a repository of T on table
+func NewRepository[T any](conn *Conn, table string) (*Repository[T], error) {
+	var zero T
+	t := reflect.TypeOf(zero)
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("repository type %s must be a struct", t)
+	}
+	r := &Repository[T]{
+		conn:    conn,
+		table:   table,
+		filters: make(map[string]string),
+		sorts:   make(map[string]string),
+	}
+	r.collect(t, nil)
+	for i := range r.columns {
+		c := &r.columns[i]
+		switch {
+		case c.pk:
+			r.pk = c
+		case c.version:
+			r.version = c
+		case c.name == "deleted_at":
+			r.softDelete = c
+		}
+	}
+	if r.pk == nil {
+		return nil, fmt.Errorf("repository type %s has no pk column", t)
+	}
+	return r, nil
+}
+
+// collect reads the columns from the db tags of t
+func (r *Repository[T]) collect(t reflect.Type, parent []int) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		index := append(append([]int{}, parent...), i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			r.collect(field.Type, index)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		c := column{name: name, index: index}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "pk":
+				c.pk = true
+			case "version":
+				c.version = true
+			case "omitempty":
+				c.omitempty = true
+			case "readonly":
+				c.readonly = true
+			}
+		}
+		r.columns = append(r.columns, c)
+	}
+}
+
+// Filterable whitelists columns that List may filter on, it panics on
+// names that are not columns of the repository
+func (r *Repository[T]) Filterable(columns ...string) *Repository[T] {
+	for _, c := range columns {
+		r.mustColumn(c)
+		r.filters[c] = c
+	}
+	return r
+}
+
+// Sortable whitelists columns that List may sort on, it panics on names
+// that are not columns of the repository
+func (r *Repository[T]) Sortable(columns ...string) *Repository[T] {
+	for _, c := range columns {
+		r.mustColumn(c)
+		r.sorts[c] = c
+	}
+	return r
+}
+
+// mustColumn panics when name is not a column of the repository
+func (r *Repository[T]) mustColumn(name string) {
+	if r.column(name) == nil {
+		panic(fmt.Sprintf("repository %s has no column %q", r.table, name))
+	}
+}
+
+// OnChange registers hook for every create, update and delete. Writes of a
+// repository with hooks run in a transaction, joining the one of ctx if any.
+func (r *Repository[T]) OnChange(hook ChangeHook) *Repository[T] {
+	r.hooks = append(r.hooks, hook)
+	return r
+}
+
+// write runs fn and the change hooks in one transaction
+func (r *Repository[T]) write(ctx context.Context, fn func(ctx context.Context) (Change, error)) error {
+	if len(r.hooks) == 0 {
+		_, err := fn(ctx)
+		return err
+	}
+	return r.conn.WithTx(ctx, nil, func(tx Tx) error {
+		ctx := tx.Context()
+		change, err := fn(ctx)
+		if err != nil {
+			return err
+		}
+		change.Table = r.table
+		for _, hook := range r.hooks {
+			if err := hook(ctx, change); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// before returns the stored record of a write when hooks need it
+func (r *Repository[T]) before(ctx context.Context, id interface{}) (interface{}, error) {
+	if len(r.hooks) == 0 {
+		return nil, nil
+	}
+	item, err := r.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// executor returns the transaction in ctx or the connection
+func (r *Repository[T]) executor(ctx context.Context) Executor {
+	if t, ok := TxFromContext(ctx); ok {
+		return t
+	}
+	return r.conn
+}
+
+// Get returns the record with the primary key id
+func (r *Repository[T]) Get(ctx context.Context, id interface{}) (T, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1%s",
+		r.selectList(), QuoteIdentifier(r.table), QuoteIdentifier(r.pk.name), r.notDeleted(" AND "))
+	return QueryOne[T](ctx, r.executor(ctx), query, id)
+}
+
+// Create inserts item and reads back the stored record
+func (r *Repository[T]) Create(ctx context.Context, item *T) error {
+	return r.write(ctx, func(ctx context.Context) (Change, error) {
+		if err := r.create(ctx, item); err != nil {
+			return Change{}, err
+		}
+		id := reflect.ValueOf(item).Elem().FieldByIndex(r.pk.index).Interface()
+		return Change{Action: ActionCreate, ID: id, After: *item}, nil
+	})
+}
+
+// create inserts item
+func (r *Repository[T]) create(ctx context.Context, item *T) error {
+	v := reflect.ValueOf(item).Elem()
+	if r.version != nil {
+		if field := v.FieldByIndex(r.version.index); field.IsZero() && field.CanInt() {
+			field.SetInt(1)
+		}
+	}
+
+	var names, placeholders []string
+	var args []interface{}
+	for _, c := range r.columns {
+		field := v.FieldByIndex(c.index)
+		if c.readonly || ((c.pk || c.omitempty) && field.IsZero()) {
+			continue
+		}
+		args = append(args, field.Interface())
+		names = append(names, QuoteIdentifier(c.name))
+		placeholders = append(placeholders, "$"+strconv.Itoa(len(args)))
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING %s",
+		QuoteIdentifier(r.table), strings.Join(names, ", "), strings.Join(placeholders, ", "), r.selectList())
+	return r.scanInto(ctx, item, query, args...)
+}
+
+// Update stores item, failing with ErrStaleVersion when the version column
+// no longer matches the stored record
+func (r *Repository[T]) Update(ctx context.Context, item *T) error {
+	return r.write(ctx, func(ctx context.Context) (Change, error) {
+		id := reflect.ValueOf(item).Elem().FieldByIndex(r.pk.index).Interface()
+		before, err := r.before(ctx, id)
+		if err != nil {
+			return Change{}, err
+		}
+		if err := r.update(ctx, item); err != nil {
+			return Change{}, err
+		}
+		return Change{Action: ActionUpdate, ID: id, Before: before, After: *item}, nil
+	})
+}
+
+// update stores item
+func (r *Repository[T]) update(ctx context.Context, item *T) error {
+	v := reflect.ValueOf(item).Elem()
+	var sets []string
+	var args []interface{}
+	for _, c := range r.columns {
+		// the soft delete marker is only written by Delete
+		if c.pk || c.version || c.readonly || (r.softDelete != nil && c.name == r.softDelete.name) {
+			continue
+		}
+		args = append(args, v.FieldByIndex(c.index).Interface())
+		sets = append(sets, fmt.Sprintf("%s = $%d", QuoteIdentifier(c.name), len(args)))
+	}
+	args = append(args, v.FieldByIndex(r.pk.index).Interface())
+	where := fmt.Sprintf("%s = $%d", QuoteIdentifier(r.pk.name), len(args))
+	if r.version != nil {
+		sets = append(sets, fmt.Sprintf("%[1]s = %[1]s + 1", QuoteIdentifier(r.version.name)))
+		args = append(args, v.FieldByIndex(r.version.index).Interface())
+		where += fmt.Sprintf(" AND %s = $%d", QuoteIdentifier(r.version.name), len(args))
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s%s RETURNING %s",
+		QuoteIdentifier(r.table), strings.Join(sets, ", "), where, r.notDeleted(" AND "), r.selectList())
+
+	err := r.scanInto(ctx, item, query, args...)
+	if errors.Is(err, sql.ErrNoRows) && r.version != nil {
+		// tell a missing record apart from a lost optimistic lock
+		if _, getErr := r.Get(WithPrimary(ctx), v.FieldByIndex(r.pk.index).Interface()); getErr == nil {
+			return ErrStaleVersion
+		}
+	}
+	return err
+}
+
+// Delete removes the record with the primary key id, or marks it deleted
+// when the table has a deleted_at column
+func (r *Repository[T]) Delete(ctx context.Context, id interface{}) error {
+	return r.write(ctx, func(ctx context.Context) (Change, error) {
+		before, err := r.before(ctx, id)
+		if err != nil {
+			return Change{}, err
+		}
+		if err := r.delete(ctx, id); err != nil {
+			return Change{}, err
+		}
+		return Change{Action: ActionDelete, ID: id, Before: before}, nil
+	})
+}
+
+// delete removes or marks the record deleted
+func (r *Repository[T]) delete(ctx context.Context, id interface{}) error {
+	var query string
+	if r.softDelete != nil {
+		query = fmt.Sprintf("UPDATE %s SET %s = CURRENT_TIMESTAMP WHERE %s = $1%s",
+			QuoteIdentifier(r.table), QuoteIdentifier(r.softDelete.name), QuoteIdentifier(r.pk.name), r.notDeleted(" AND "))
+	} else {
+		query = fmt.Sprintf("DELETE FROM %s WHERE %s = $1", QuoteIdentifier(r.table), QuoteIdentifier(r.pk.name))
+	}
+	result, err := r.executor(ctx).Delete(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Filter is a single filter of a list query
+type Filter struct {
+	Column   string
+	Operator string
+	Value    string
+}
+
+// Sort is a single sort of a list query
+type Sort struct {
+	Column string
+	Desc   bool
+}
+
+// ListParams struct
+type ListParams struct {
+	Filters []Filter
+	Sorts   []Sort
+	Limit   int
+	Offset  int
+	// After is the cursor of the previous page, it replaces Offset
+	After string
+}
+
+// Page struct
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
+// ReservedParams are query string keys that are never read as filters,
+// such as the jwt of server.JWT or the tracking parameters of links. Keys
+// starting with utm_ are reserved as well.
+var ReservedParams = map[string]bool{
+	"jwt":          true,
+	"access_token": true,
+	"fbclid":       true,
+	"gclid":        true,
+}
+
+// operators maps the filter operators of a query string to SQL
+var operators = map[string]string{
+	"eq":   "=",
+	"ne":   "<>",
+	"gt":   ">",
+	"gte":  ">=",
+	"lt":   "<",
+	"lte":  "<=",
+	"like": "LIKE",
+	"in":   "IN",
+}
+
+// ParseListParams reads filters, sorts and pagination from a query string
+// such as ?status=active&age[gte]=18&sort=-created_at,name&limit=50&after=...
+// Reserved keys are skipped, and List ignores filters on columns that are not
+// Filterable, so other parameters of a request may share the query string.
+func ParseListParams(values url.Values) (ListParams, error) {
+	params := ListParams{Limit: DefaultLimit}
+	for key, vals := range values {
+		switch key {
+		case "limit":
+			limit, err := strconv.Atoi(vals[0])
+			if err != nil || limit < 1 {
+				return params, fmt.Errorf("invalid limit %q", vals[0])
+			}
+			params.Limit = min(limit, MaxLimit)
+		case "offset":
+			offset, err := strconv.Atoi(vals[0])
+			if err != nil || offset < 0 {
+				return params, fmt.Errorf("invalid offset %q", vals[0])
+			}
+			params.Offset = offset
+		case "after":
+			params.After = vals[0]
+		case "sort":
+			for _, s := range strings.Split(vals[0], ",") {
+				if s == "" {
+					continue
+				}
+				sort := Sort{Column: strings.TrimPrefix(s, "-"), Desc: strings.HasPrefix(s, "-")}
+				params.Sorts = append(params.Sorts, sort)
+			}
+		default:
+			if ReservedParams[key] || strings.HasPrefix(key, "utm_") {
+				continue
+			}
+			name, op := key, "eq"
+			if i := strings.Index(key, "["); i > 0 && strings.HasSuffix(key, "]") {
+				name, op = key[:i], key[i+1:len(key)-1]
+			}
+			if _, ok := operators[op]; !ok {
+				return params, fmt.Errorf("invalid filter operator %q", op)
+			}
+			for _, v := range vals {
+				params.Filters = append(params.Filters, Filter{Column: name, Operator: op, Value: v})
+			}
+		}
+	}
+	return params, nil
+}
+
+// List returns a page of records matching the whitelisted filters, filters
+// on other columns are ignored. The limit is capped at MaxLimit.
+func (r *Repository[T]) List(ctx context.Context, params ListParams) (Page[T], error) {
+	var page Page[T]
+	var where []string
+	var args []interface{}
+	if r.softDelete != nil {
+		where = append(where, r.notDeleted(""))
+	}
+	for _, f := range params.Filters {
+		column, ok := r.filters[f.Column]
+		if !ok {
+			continue
+		}
+		if f.Operator == "in" {
+			var placeholders []string
+			for _, v := range strings.Split(f.Value, ",") {
+				args = append(args, v)
+				placeholders = append(placeholders, "$"+strconv.Itoa(len(args)))
+			}
+			where = append(where, fmt.Sprintf("%s IN (%s)", QuoteIdentifier(column), strings.Join(placeholders, ", ")))
+			continue
+		}
+		args = append(args, f.Value)
+		where = append(where, fmt.Sprintf("%s %s $%d", QuoteIdentifier(column), operators[f.Operator], len(args)))
+	}
+
+	// the primary key breaks ties so that pages are stable
+	sorts := make([]Sort, 0, len(params.Sorts)+1)
+	for _, s := range params.Sorts {
+		column, ok := r.sorts[s.Column]
+		if !ok || r.column(column) == nil {
+			return page, fmt.Errorf("sorting on %q is not allowed", s.Column)
+		}
+		sorts = append(sorts, Sort{Column: column, Desc: s.Desc})
+	}
+	sorts = append(sorts, Sort{Column: r.pk.name, Desc: len(sorts) > 0 && sorts[0].Desc})
+
+	if params.After != "" {
+		condition, cursorArgs, err := r.afterCursor(params.After, sorts, len(args))
+		if err != nil {
+			return page, err
+		}
+		where = append(where, condition)
+		args = append(args, cursorArgs...)
+	}
+
+	limit := min(params.Limit, MaxLimit)
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", r.selectList(), QuoteIdentifier(r.table))
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// nulls sort last in both directions and on every dialect, which the
+	// keyset condition of afterCursor relies on
+	var order []string
+	for _, s := range sorts {
+		direction := "ASC"
+		if s.Desc {
+			direction = "DESC"
+		}
+		order = append(order, QuoteIdentifier(s.Column)+" "+direction+" NULLS LAST")
+	}
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY %s LIMIT $%d", strings.Join(order, ", "), len(args))
+	if params.After == "" && params.Offset > 0 {
+		args = append(args, params.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	items, err := QueryStructs[T](ctx, r.executor(ctx), query, args...)
+	if err != nil {
+		return page, err
+	}
+	if len(items) > limit {
+		items = items[:limit]
+		page.HasMore = true
+		page.NextCursor, err = r.cursor(items[len(items)-1], sorts)
+		if err != nil {
+			return page, err
+		}
+	}
+	if items == nil {
+		items = []T{}
+	}
+	page.Items = items
+	return page, nil
+}
+
+// cursor encodes the sort values of the last item of a page
+func (r *Repository[T]) cursor(item T, sorts []Sort) (string, error) {
+	v := reflect.ValueOf(item)
+	values := make([]interface{}, 0, len(sorts))
+	for _, s := range sorts {
+		c := r.column(s.Column)
+		value := v.FieldByIndex(c.index).Interface()
+		if valuer, ok := value.(driver.Valuer); ok {
+			// sql.Null* and similar types encode as their value or null
+			var err error
+			if value, err = valuer.Value(); err != nil {
+				return "", err
+			}
+		}
+		values = append(values, value)
+	}
+	body, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(body), nil
+}
+
+// afterCursor builds the keyset condition selecting rows after a cursor
+func (r *Repository[T]) afterCursor(cursor string, sorts []Sort, offset int) (string, []interface{}, error) {
+	body, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", nil, errors.New("invalid cursor")
+	}
+	// numbers stay strings so that large keys keep their precision
+	var values []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil || len(values) != len(sorts) {
+		return "", nil, errors.New("invalid cursor")
+	}
+	for i, value := range values {
+		if n, ok := value.(json.Number); ok {
+			values[i] = n.String()
+		}
+	}
+
+	// only the values that are not null are bound
+	var args []interface{}
+	placeholders := make([]string, len(values))
+	for i, value := range values {
+		if value != nil {
+			args = append(args, value)
+			placeholders[i] = "$" + strconv.Itoa(offset+len(args))
+		}
+	}
+
+	// (a > x) OR (a = x AND b > y) ... honouring each sort direction, with
+	// nulls after every value
+	var alternatives []string
+	for i := range sorts {
+		var terms []string
+		for j := 0; j < i; j++ {
+			column := QuoteIdentifier(sorts[j].Column)
+			if values[j] == nil {
+				terms = append(terms, column+" IS NULL")
+			} else {
+				terms = append(terms, column+" = "+placeholders[j])
+			}
+		}
+		if values[i] == nil {
+			// nothing sorts after a null but the following columns
+			continue
+		}
+		op := ">"
+		if sorts[i].Desc {
+			op = "<"
+		}
+		column := QuoteIdentifier(sorts[i].Column)
+		terms = append(terms, fmt.Sprintf("(%s %s %s OR %s IS NULL)", column, op, placeholders[i], column))
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+	}
+	if len(alternatives) == 0 {
+		return "1 = 0", nil, nil
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args, nil
+}
+
+// column returns the column with name
+func (r *Repository[T]) column(name string) *column {
+	for i := range r.columns {
+		if r.columns[i].name == name {
+			return &r.columns[i]
+		}
+	}
+	return nil
+}
+
+// selectList returns the quoted column list of the table
+func (r *Repository[T]) selectList() string {
+	names := make([]string, len(r.columns))
+	for i, c := range r.columns {
+		names[i] = QuoteIdentifier(c.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// notDeleted returns the soft delete condition prefixed by join
+func (r *Repository[T]) notDeleted(join string) string {
+	if r.softDelete == nil {
+		return ""
+	}
+	return join + QuoteIdentifier(r.softDelete.name) + " IS NULL"
+}
+
+// scanInto runs a write returning a row and stores the row in item
+func (r *Repository[T]) scanInto(ctx context.Context, item *T, query string, args ...interface{}) error {
+	stored, err := QueryOne[T](ctx, Writer(r.executor(ctx)), query, args...)
+	if err != nil {
+		return err
+	}
+	*item = stored
+	return nil
+}
@@ -0,0 +1,153 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/greatfocus/gf-sframe/database"
+)
+
+// account is a versioned, soft deleted record
+type account struct {
+	ID        int64          `db:"id,pk"`
+	Name      string         `db:"name"`
+	Rank      sql.NullInt64  `db:"rank"`
+	Version   int64          `db:"version,version"`
+	DeletedAt sql.NullString `db:"deleted_at,readonly"`
+}
+
+// accounts creates the accounts table and its repository
+func accounts(t *testing.T) *database.Repository[account] {
+	t.Helper()
+	conn := memory(t)
+	_, err := conn.Exec(context.Background(), `CREATE TABLE accounts (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		rank INTEGER,
+		version INTEGER NOT NULL,
+		deleted_at TEXT
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := database.NewRepository[account](conn, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo.Filterable("name").Sortable("rank")
+}
+
+func TestRepositoryCRUD(t *testing.T) {
+	repo := accounts(t)
+	ctx := context.Background()
+
+	ann := account{Name: "ann"}
+	if err := repo.Create(ctx, &ann); err != nil {
+		t.Fatal(err)
+	}
+	if ann.ID == 0 || ann.Version != 1 {
+		t.Fatalf("created %+v, want an id and version 1", ann)
+	}
+
+	stale := ann
+	ann.Name = "anne"
+	if err := repo.Update(ctx, &ann); err != nil {
+		t.Fatal(err)
+	}
+	if ann.Version != 2 {
+		t.Fatalf("updated to version %d, want 2", ann.Version)
+	}
+	stale.Name = "annie"
+	if err := repo.Update(ctx, &stale); !errors.Is(err, database.ErrStaleVersion) {
+		t.Fatalf("stale update returned %v", err)
+	}
+	got, err := repo.Get(ctx, ann.ID)
+	if err != nil || got.Name != "anne" {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+
+	if err := repo.Delete(ctx, ann.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, ann.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("deleted record read with %v", err)
+	}
+	if err := repo.Delete(ctx, ann.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("second delete returned %v", err)
+	}
+	missing := account{ID: ann.ID, Name: "ghost", Version: ann.Version}
+	if err := repo.Update(ctx, &missing); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("update of a deleted record returned %v", err)
+	}
+	page, err := repo.List(ctx, database.ListParams{})
+	if err != nil || len(page.Items) != 0 {
+		t.Fatalf("listed %v, %v", page.Items, err)
+	}
+	if page.Items == nil {
+		t.Fatal("empty page has nil items")
+	}
+}
+
+func TestRepositoryList(t *testing.T) {
+	repo := accounts(t)
+	ctx := context.Background()
+	ranks := []sql.NullInt64{{Int64: 3, Valid: true}, {}, {Int64: 1, Valid: true}, {}, {Int64: 2, Valid: true}, {Int64: 3, Valid: true}}
+	for i, rank := range ranks {
+		item := account{Name: string(rune('a' + i)), Rank: rank}
+		if err := repo.Create(ctx, &item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for sort, want := range map[string]string{"rank": "cea fbd", "-rank": "fae cdb"} {
+		params, err := database.ParseListParams(url.Values{
+			"sort": {sort}, "limit": {"3"}, "jwt": {"token"}, "utm_source": {"mail"}, "other": {"x"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		for {
+			page, err := repo.List(ctx, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "" {
+				got += " "
+			}
+			for _, item := range page.Items {
+				got += item.Name
+			}
+			if !page.HasMore {
+				break
+			}
+			params.After = page.NextCursor
+		}
+		// the null ranks sort last whichever the direction
+		if got != want {
+			t.Errorf("sorted by %s into pages %q, want %q", sort, got, want)
+		}
+	}
+
+	params, err := database.ParseListParams(url.Values{"name[in]": {"a,c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := repo.List(ctx, params)
+	if err != nil || len(page.Items) != 2 {
+		t.Fatalf("filtered %v, %v", page.Items, err)
+	}
+}
+
+func TestRepositoryUnknownColumn(t *testing.T) {
+	repo := accounts(t)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("sorting on a field that is not a column did not panic")
+		}
+	}()
+	repo.Sortable("Rank")
+}
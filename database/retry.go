@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Defaults of the connection resilience
+const (
+	defaultStartupTimeout = time.Minute
+	maxBackoff            = 5 * time.Second
+)
+
+// ErrorKind classifies database failures
+type ErrorKind int
+
+// Kinds of database failures
+const (
+	KindUnknown ErrorKind = iota
+	// KindConnection is a refused, reset or broken connection
+	KindConnection
+	// KindShutdown is a server shutting down or starting up
+	KindShutdown
+	// KindTooManyConnections is a server out of connection slots
+	KindTooManyConnections
+	// KindTimeout is a query cancelled by a deadline
+	KindTimeout
+	// KindConflict is a serialization failure, deadlock or recovery
+	// conflict on a replica
+	KindConflict
+)
+
+// String returns the name of the kind
+func (k ErrorKind) String() string {
+	switch k {
+	case KindConnection:
+		return "connection"
+	case KindShutdown:
+		return "shutdown"
+	case KindTooManyConnections:
+		return "too many connections"
+	case KindTimeout:
+		return "timeout"
+	case KindConflict:
+		return "conflict"
+	}
+	return "unknown"
+}
+
+// Error is a classified database failure
+type Error struct {
+	Kind     ErrorKind
+	Op       string
+	Database string
+	Err      error
+}
+
+// Error returns the message of the failure
+func (e *Error) Error() string {
+	return fmt.Sprintf("database %s %s failed (%s): %v", e.Database, e.Op, e.Kind, e.Err)
+}
+
+// Unwrap returns the driver error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Temporary checks if the operation may succeed when retried
+func (e *Error) Temporary() bool {
+	switch e.Kind {
+	case KindConnection, KindShutdown, KindTooManyConnections, KindConflict:
+		return true
+	}
+	return false
+}
+
+// IsTransient checks if err is a failure that may succeed when retried
+func IsTransient(err error) bool {
+	var dbErr *Error
+	if errors.As(err, &dbErr) {
+		return dbErr.Temporary()
+	}
+	return (&Error{Kind: classify(err)}).Temporary()
+}
+
+// classify returns the kind of a driver error
+func classify(err error) ErrorKind {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		code := state.SQLState()
+		switch {
+		case code == "57P01", code == "57P02", code == "57P03":
+			return KindShutdown
+		case code == "53300":
+			return KindTooManyConnections
+		case code == "57014":
+			return KindTimeout
+		case code == "40001", code == "40P01":
+			return KindConflict
+		case strings.HasPrefix(code, "08"):
+			return KindConnection
+		}
+		return KindUnknown
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return KindTimeout
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE):
+		return KindConnection
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return KindTimeout
+		}
+		return KindConnection
+	}
+	return KindUnknown
+}
+
+// wrap returns err as an *Error when it is a classified failure, other
+// errors such as constraint violations are returned as is
+func (d *db) wrap(op string, err error) error {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	var dbErr *Error
+	if errors.As(err, &dbErr) {
+		return err
+	}
+	kind := classify(err)
+	if kind == KindUnknown {
+		return err
+	}
+	return &Error{Kind: kind, Op: op, Database: d.name, Err: err}
+}
+
+// backoff returns the exponential delay with jitter before a retry
+func backoff(attempt int, base time.Duration) time.Duration {
+	delay := min(base<<attempt, maxBackoff)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// sleep waits for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// waitReady pings the database with exponential backoff until it answers
+// or timeout passes
+func (d *db) waitReady(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for attempt := 0; ; attempt++ {
+		err := d.conn.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		err = d.wrap("ping", err)
+		if !IsTransient(err) && ctx.Err() == nil {
+			return err
+		}
+		log.Println("Waiting for database", d.name, err)
+		if sleep(ctx, backoff(attempt, 250*time.Millisecond)) != nil {
+			return &Error{Kind: KindConnection, Op: "ping", Database: d.name, Err: err}
+		}
+	}
+}
+
+// retryRead runs a read on a reader, retrying transient failures on the
+// next reader. Replicas failing with broken connections are taken out of
+// rotation until the health check restores them.
+func (c *Conn) retryRead(ctx context.Context, op string, fn func(reader *db) error) error {
+	for attempt := 0; ; attempt++ {
+		reader := c.reader(ctx)
+		err := reader.wrap(op, fn(reader))
+		if err == nil || !IsTransient(err) || attempt >= c.retries {
+			return err
+		}
+
+		var dbErr *Error
+		if reader != c.master && errors.As(err, &dbErr) &&
+			(dbErr.Kind == KindConnection || dbErr.Kind == KindShutdown) {
+			if reader.healthy.Swap(false) {
+				log.Println("Database replica out of rotation:", reader.name, err)
+			}
+		}
+		if sleep(ctx, backoff(attempt, 50*time.Millisecond)) != nil {
+			return err
+		}
+	}
+}
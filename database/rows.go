@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Rows wraps sql.Rows and releases the query context once the rows are
+// exhausted or closed. The span and statistics of the query cover the
+// iteration of the rows. The tenant transaction of the query commits then,
+// or rolls back when a row failed to scan, and Err and Close return the
+// error of the commit.
+type Rows struct {
+	*sql.Rows
+	release func(err error) error
+	call    *call
+	// scanErr is the first failed Scan of the caller
+	scanErr error
+	// endErr is the error of ending the scope of the query
+	endErr error
+}
+
+// Next prepares the next row and releases the context after the last one
+func (r *Rows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.finish(r.Rows.Err())
+	return false
+}
+
+// Scan copies the columns of the current row into dest
+func (r *Rows) Scan(dest ...interface{}) error {
+	err := r.Rows.Scan(dest...)
+	if err != nil && r.scanErr == nil {
+		r.scanErr = err
+	}
+	return err
+}
+
+// Err returns the error of the iteration or of ending its scope
+func (r *Rows) Err() error {
+	if err := r.Rows.Err(); err != nil {
+		return err
+	}
+	return r.endErr
+}
+
+// Close closes the rows and releases the query context
+func (r *Rows) Close() error {
+	if err := r.Rows.Close(); err != nil {
+		r.finish(err)
+		return err
+	}
+	r.finish(r.Rows.Err())
+	return r.endErr
+}
+
+// finish ends the call once and releases the query context
+func (r *Rows) finish(err error) {
+	if r.call != nil {
+		r.call.end(err)
+		r.call = nil
+	}
+	if err == nil {
+		err = r.scanErr
+	}
+	if endErr := r.release(err); r.endErr == nil {
+		r.endErr = endErr
+	}
+}
+
+// Row wraps sql.Row and releases the query context once it is scanned,
+// closed, or found to have failed. The tenant transaction of the query
+// commits then, and Scan and Close return the error of the commit.
+type Row struct {
+	row     *sql.Row
+	release func(err error) error
+	// err is the classified error of running the query
+	err error
+}
+
+// Scan copies the columns of the row into dest
+func (r *Row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		_ = r.release(r.err)
+		return r.err
+	}
+	err := r.row.Scan(dest...)
+	if endErr := r.release(err); err == nil {
+		err = endErr
+	}
+	return err
+}
+
+// Err returns the error of running the query, a failed row is released
+func (r *Row) Err() error {
+	err := r.err
+	if err == nil {
+		err = r.row.Err()
+	}
+	if err != nil {
+		_ = r.release(err)
+	}
+	return err
+}
+
+// Close releases a row that will not be scanned
+func (r *Row) Close() error {
+	if r.err != nil {
+		return r.release(r.err)
+	}
+	// a Scan without destinations closes the result, which the commit of
+	// the tenant transaction waits for
+	_ = r.row.Scan()
+	return r.release(nil)
+}
+
+// withTimeout applies the configured query timeout of the database, a
+// zero timeout leaves the deadline to the caller
+func (d *db) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(d.timeout)*time.Second)
+}
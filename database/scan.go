@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Querier runs read queries, it is satisfied by Conn and Tx
+type Querier interface {
+	Query(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+}
+
+// fieldCache holds the column to field mapping of each struct type
+var fieldCache sync.Map
+
+// QueryStructs runs query and maps every row to a T, matching columns to
+// fields through their db tag or, without a tag, their lower case name
+func QueryStructs[T any](ctx context.Context, q Querier, query string, args ...interface{}) ([]T, error) {
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var zero T
+	fields, err := columnFields(reflect.TypeOf(zero), columns)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []T
+	dest := make([]interface{}, len(columns))
+	for rows.Next() {
+		var item T
+		v := reflect.ValueOf(&item).Elem()
+		for i, index := range fields {
+			if index == nil {
+				dest[i] = new(interface{})
+				continue
+			}
+			dest[i] = v.FieldByIndex(index).Addr().Interface()
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+// QueryOne runs query and maps the first row to a T, returning
+// sql.ErrNoRows when there is none
+func QueryOne[T any](ctx context.Context, q Querier, query string, args ...interface{}) (T, error) {
+	var zero T
+	items, err := QueryStructs[T](ctx, q, query, args...)
+	if err != nil {
+		return zero, err
+	}
+	if len(items) == 0 {
+		return zero, sql.ErrNoRows
+	}
+	return items[0], nil
+}
+
+// QueryScalar runs query and returns the single column of the first row,
+// returning sql.ErrNoRows when there is none
+func QueryScalar[T any](ctx context.Context, q Querier, query string, args ...interface{}) (T, error) {
+	var value T
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return value, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return value, err
+		}
+		return value, sql.ErrNoRows
+	}
+	if err := rows.Scan(&value); err != nil {
+		return value, err
+	}
+	return value, rows.Close()
+}
+
+// columnFields returns the field index of each column, nil for columns
+// without a matching field
+func columnFields(t reflect.Type, columns []string) ([][]int, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot scan rows into %s, expected a struct", t)
+	}
+	byColumn := structFields(t)
+	fields := make([][]int, len(columns))
+	for i, column := range columns {
+		fields[i] = byColumn[strings.ToLower(column)]
+	}
+	return fields, nil
+}
+
+// structFields maps the column names of a struct to its field indexes
+func structFields(t reflect.Type) map[string][]int {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.(map[string][]int)
+	}
+	fields := make(map[string][]int)
+	collectFields(t, nil, fields)
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// collectFields walks the exported fields, flattening embedded structs
+func collectFields(t reflect.Type, parent []int, fields map[string][]int) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		index := append(append([]int{}, parent...), i)
+		tag := field.Tag.Get("db")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			collectFields(field.Type, index, fields)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		name = strings.ToLower(name)
+		if _, exists := fields[name]; !exists {
+			fields[name] = index
+		}
+	}
+}
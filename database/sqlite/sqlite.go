@@ -0,0 +1,25 @@
+// Package sqlite registers the pure Go SQLite driver used by the sqlite
+// dialect of the database package. Import it in tests, or in services
+// configured with the sqlite driver, to run without a Postgres server.
+package sqlite
+
+import (
+	"github.com/greatfocus/gf-sframe/config"
+	"github.com/greatfocus/gf-sframe/database"
+
+	// registers the sqlite driver with database/sql
+	_ "modernc.org/sqlite"
+)
+
+// Memory opens a private in-memory database, which is dropped once closed
+func Memory() (*database.Conn, error) {
+	return Open(":memory:")
+}
+
+// Open opens the database file at path, creating it when missing
+func Open(path string) (*database.Conn, error) {
+	return database.Open(config.Database{
+		Driver: database.SQLite.Name(),
+		Master: config.DatabaseType{Database: path},
+	})
+}
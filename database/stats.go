@@ -0,0 +1,335 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Limits of the query statistics
+const (
+	maxFingerprints = 1000
+	maxSamples      = 512
+	otherQueries    = "other"
+)
+
+// callerKey is the context key of the route or job running a query
+type callerKey struct{}
+
+// WithCaller returns a context attributing its queries to caller, such as
+// the route of a request or the name of a job
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller of ctx
+func CallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// QueryStat reports the calls of a query fingerprint
+type QueryStat struct {
+	Fingerprint string  `json:"fingerprint"`
+	Count       int64   `json:"count"`
+	Errors      int64   `json:"errors"`
+	TotalMs     float64 `json:"totalMs"`
+	P50Ms       float64 `json:"p50Ms"`
+	P95Ms       float64 `json:"p95Ms"`
+	MaxMs       float64 `json:"maxMs"`
+}
+
+// queryStat collects the calls of a fingerprint, keeping the latest
+// durations for the percentiles
+type queryStat struct {
+	count   int64
+	errors  int64
+	total   time.Duration
+	max     time.Duration
+	samples []time.Duration
+	next    int
+}
+
+// queryLog records the statistics of every query and logs slow ones
+type queryLog struct {
+	threshold time.Duration
+	mu        sync.Mutex
+	logger    *slog.Logger
+	stats     map[string]*queryStat
+}
+
+// newQueryLog creates a query log, a zero threshold disables the slow log
+func newQueryLog(threshold time.Duration) *queryLog {
+	return &queryLog{
+		threshold: threshold,
+		logger:    slog.Default(),
+		stats:     make(map[string]*queryStat),
+	}
+}
+
+// call is a running database call
+type call struct {
+	log       *queryLog
+	span      trace.Span
+	start     time.Time
+	operation string
+	query     string
+	args      []interface{}
+	caller    string
+}
+
+// start begins a traced and measured database call
+func (d *db) start(ctx context.Context, operation, query string, args []interface{}) (context.Context, *call) {
+	caller := CallerFromContext(ctx)
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", d.dialect.System()),
+		attribute.String("db.operation", operation),
+		attribute.String("db.statement", query),
+		attribute.String("db.instance", d.name),
+	}
+	if caller != "" {
+		attrs = append(attrs, attribute.String("db.caller", caller))
+	}
+	ctx, span := tracing.Start(ctx, "db."+operation,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx, &call{
+		log:       d.queries,
+		span:      span,
+		start:     time.Now(),
+		operation: operation,
+		query:     query,
+		args:      args,
+		caller:    caller,
+	}
+}
+
+// end records the outcome of the call
+func (c *call) end(err error) {
+	tracing.End(c.span, err)
+	if c.log != nil {
+		c.log.record(c, time.Since(c.start), err)
+	}
+}
+
+// record adds a call to the statistics and logs it when slow
+func (l *queryLog) record(c *call, duration time.Duration, err error) {
+	fingerprint := Fingerprint(c.query)
+
+	l.mu.Lock()
+	stat, ok := l.stats[fingerprint]
+	if !ok {
+		if len(l.stats) >= maxFingerprints {
+			fingerprint = otherQueries
+			stat = l.stats[otherQueries]
+		}
+		if stat == nil {
+			stat = &queryStat{}
+			l.stats[fingerprint] = stat
+		}
+	}
+	stat.count++
+	if err != nil {
+		stat.errors++
+	}
+	stat.total += duration
+	stat.max = max(stat.max, duration)
+	if len(stat.samples) < maxSamples {
+		stat.samples = append(stat.samples, duration)
+	} else {
+		stat.samples[stat.next] = duration
+		stat.next = (stat.next + 1) % maxSamples
+	}
+	logger := l.logger
+	l.mu.Unlock()
+
+	if l.threshold > 0 && duration >= l.threshold {
+		attrs := []interface{}{
+			"operation", c.operation,
+			"fingerprint", fingerprint,
+			"caller", c.caller,
+			"duration", duration,
+			"args", redactArgs(c.args),
+		}
+		if err != nil {
+			attrs = append(attrs, "error", err.Error())
+		}
+		logger.Warn("slow query", attrs...)
+	}
+}
+
+// snapshot returns the statistics sorted by total duration
+func (l *queryLog) snapshot() []QueryStat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := make([]QueryStat, 0, len(l.stats))
+	for fingerprint, stat := range l.stats {
+		samples := append([]time.Duration(nil), stat.samples...)
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		stats = append(stats, QueryStat{
+			Fingerprint: fingerprint,
+			Count:       stat.count,
+			Errors:      stat.errors,
+			TotalMs:     milliseconds(stat.total),
+			P50Ms:       milliseconds(percentile(samples, 0.50)),
+			P95Ms:       milliseconds(percentile(samples, 0.95)),
+			MaxMs:       milliseconds(stat.max),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].TotalMs > stats[j].TotalMs })
+	return stats
+}
+
+// QueryStats returns the statistics of each query fingerprint, slowest
+// in total first
+func (c *Conn) QueryStats() []QueryStat {
+	return c.queries.snapshot()
+}
+
+// ResetQueryStats clears the query statistics
+func (c *Conn) ResetQueryStats() {
+	c.queries.mu.Lock()
+	defer c.queries.mu.Unlock()
+	c.queries.stats = make(map[string]*queryStat)
+}
+
+// SetLogger sets the logger of the slow query log
+func (c *Conn) SetLogger(logger *slog.Logger) {
+	c.queries.mu.Lock()
+	defer c.queries.mu.Unlock()
+	c.queries.logger = logger
+}
+
+// percentile returns the p-th percentile of sorted samples
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	return samples[int(p*float64(len(samples)-1))]
+}
+
+// milliseconds converts d to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// redactArgs keeps numbers, booleans and times of query arguments and
+// redacts everything else
+func redactArgs(args []interface{}) []interface{} {
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch arg.(type) {
+		case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+			float32, float64, time.Time:
+			redacted[i] = arg
+		default:
+			redacted[i] = "REDACTED"
+		}
+	}
+	return redacted
+}
+
+// Collapsing of repeated placeholders in IN lists and multi-row VALUES
+var (
+	placeholderList = regexp.MustCompile(`\(\?(?: ?, ?\?)+\)`)
+	valuesList      = regexp.MustCompile(`\(\?\)(?: ?, ?\(\?\))+`)
+)
+
+// Fingerprint normalizes a query so that calls differing only in literals,
+// placeholders, comments, case or whitespace share a fingerprint
+func Fingerprint(query string) string {
+	var sb strings.Builder
+	space := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '-' && i+1 < len(query) && query[i+1] == '-':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case ch == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 3
+			}
+			space = true
+			continue
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f':
+			space = true
+			continue
+		}
+
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		switch {
+		case ch == '\'':
+			// string literal with '' escapes
+			for i++; i < len(query); i++ {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			sb.WriteByte('?')
+		case ch == '"':
+			// quoted identifiers keep their case
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			sb.WriteString(query[i : i+end+2])
+			i += end + 1
+		case ch == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			for i+1 < len(query) && isDigit(query[i+1]) {
+				i++
+			}
+			sb.WriteByte('?')
+		case isDigit(ch) && !isIdentifier(lastByte(&sb)):
+			for i+1 < len(query) && (isDigit(query[i+1]) || query[i+1] == '.') {
+				i++
+			}
+			sb.WriteByte('?')
+		case ch >= 'A' && ch <= 'Z':
+			sb.WriteByte(ch + 'a' - 'A')
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	fingerprint := placeholderList.ReplaceAllString(sb.String(), "(?)")
+	return valuesList.ReplaceAllString(fingerprint, "(?)")
+}
+
+// isDigit checks if ch is an ascii digit
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// isIdentifier checks if ch can be part of an identifier
+func isIdentifier(ch byte) bool {
+	return ch == '_' || isDigit(ch) || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+// lastByte returns the last written byte of sb
+func lastByte(sb *strings.Builder) byte {
+	s := sb.String()
+	if s == "" {
+		return 0
+	}
+	return s[len(s)-1]
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Tenancy modes
+const (
+	// TenantRLS sets a session variable read by row level security policies
+	TenantRLS = "rls"
+	// TenantSchema points the search_path at a schema per tenant
+	TenantSchema = "schema"
+)
+
+// maxTenantLength keeps prefixed schema names within the 63 byte limit of
+// Postgres identifiers
+const maxTenantLength = 48
+
+// ErrInvalidTenant is returned for tenant ids outside [A-Za-z0-9_-]
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+// tenantKey is the context key of the tenant
+type tenantKey struct{}
+
+// WithTenant returns a context whose queries are scoped to tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant of ctx
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+// ValidTenant checks if tenant is a non empty id of letters, digits,
+// underscores and dashes
+func ValidTenant(tenant string) bool {
+	if tenant == "" || len(tenant) > maxTenantLength {
+		return false
+	}
+	for i := 0; i < len(tenant); i++ {
+		if ch := tenant[i]; !isIdentifier(ch) && ch != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Tenancy scopes the queries of a context carrying a tenant. Queries
+// outside a transaction run in a short transaction, so that the setting
+// is local to it and never leaks to other requests through the pool.
+type Tenancy struct {
+	// Mode is TenantRLS or TenantSchema
+	Mode string
+	// Variable is the session variable of TenantRLS, such as app.tenant_id
+	// read with current_setting('app.tenant_id', true) by the policies
+	Variable string
+	// SchemaPrefix is prepended to the tenant to name its schema
+	SchemaPrefix string
+}
+
+// Schema returns the schema of tenant
+func (t *Tenancy) Schema(tenant string) string {
+	return t.SchemaPrefix + tenant
+}
+
+// apply scopes the session of q to tenant, for the current transaction
+// only when local is set
+func (t *Tenancy) apply(ctx context.Context, q queryer, tenant string, local bool) error {
+	if !ValidTenant(tenant) {
+		return fmt.Errorf("%w %q", ErrInvalidTenant, tenant)
+	}
+	var err error
+	if t.Mode == TenantSchema {
+		_, err = q.ExecContext(ctx, "SELECT set_config('search_path', $1, $2)",
+			QuoteIdentifier(t.Schema(tenant))+", public", local)
+	} else {
+		_, err = q.ExecContext(ctx, "SELECT set_config($1, $2, $3)", t.Variable, tenant, local)
+	}
+	return err
+}
+
+// SetTenancy enables tenant scoping of the queries of the connection
+func (c *Conn) SetTenancy(t *Tenancy) error {
+	if err := unsupported(c.master.dialect, "tenancy"); err != nil {
+		return err
+	}
+	switch t.Mode {
+	case TenantRLS:
+		if t.Variable == "" {
+			return errors.New("tenancy variable is required")
+		}
+	case TenantSchema:
+	default:
+		return fmt.Errorf("unknown tenancy mode %q", t.Mode)
+	}
+	c.tenancy = t
+	return nil
+}
+
+// queryer is satisfied by sql.DB, sql.Conn and sql.Tx
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// scope returns where to run a query on d: the database itself, or a
+// transaction scoped to the tenant of ctx. end commits the transaction, or
+// rolls it back on error, and is safe to call more than once.
+func (c *Conn) scope(ctx context.Context, d *db) (q queryer, end func(err error) error, err error) {
+	tenant, ok := TenantFromContext(ctx)
+	if c.tenancy == nil || !ok {
+		return d.conn, func(error) error { return nil }, nil
+	}
+	sqlTx, err := d.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = c.tenancy.apply(ctx, sqlTx, tenant, true); err != nil {
+		_ = sqlTx.Rollback()
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	var endErr error
+	end = func(err error) error {
+		once.Do(func() {
+			if err != nil {
+				endErr = sqlTx.Rollback()
+				return
+			}
+			endErr = sqlTx.Commit()
+		})
+		return endErr
+	}
+	return sqlTx, end, nil
+}
+
+// release returns a func ending the scope of a query once, with the error
+// of its caller: end commits the tenant transaction or rolls it back on
+// error, then the context is released and after runs when committed. It
+// returns the error of end, which may be nil outside a tenant scope.
+func release(cancel context.CancelFunc, end func(err error) error, after func()) func(err error) error {
+	var once sync.Once
+	var endErr error
+	return func(err error) error {
+		once.Do(func() {
+			if end != nil {
+				endErr = end(err)
+			}
+			cancel()
+			if err == nil && endErr == nil && after != nil {
+				after()
+			}
+		})
+		return endErr
+	}
+}
+
+// NewTenantMigrator creates a migrator applying migrations to the schema of
+// tenant, creating the schema if needed. It needs TenantSchema tenancy.
+func NewTenantMigrator(c *Conn, tenant string, migrations []Migration) (*Migrator, error) {
+	if c.tenancy == nil || c.tenancy.Mode != TenantSchema {
+		return nil, errors.New("tenant migrations need schema tenancy")
+	}
+	if !ValidTenant(tenant) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidTenant, tenant)
+	}
+	m, err := NewMigrator(c, migrations)
+	if err != nil {
+		return nil, err
+	}
+	m.tenant = tenant
+	m.tenancy = c.tenancy
+	return m, nil
+}
+
+// migrateTenants applies the tenant migrations of the impl to each tenant
+func (c *Conn) migrateTenants(tenants []string, migrations []Migration, leaderOnly bool) {
+	for _, tenant := range tenants {
+		migrator, err := NewTenantMigrator(c, tenant, migrations)
+		if err != nil {
+			log.Fatal(fmt.Println(err))
+		}
+		migrator.LeaderOnly = leaderOnly
+		pending, err := applyMigrations(migrator)
+		if err != nil {
+			log.Fatal(fmt.Println(err))
+		}
+		log.Println("Tenant migrations successfully executed:", tenant, len(pending))
+	}
+}
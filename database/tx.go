@@ -0,0 +1,245 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/tracing"
+)
+
+// serializationFailure is the SQLSTATE of a transaction that lost a
+// serialization conflict and can be retried
+const serializationFailure = "40001"
+
+// defaultRetries is the number of retries on serialization failures
+const defaultRetries = 3
+
+// txKey is the context key of the active transaction
+type txKey struct{}
+
+// TxOptions struct
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// Retries on serialization failure, zero uses the default and a
+	// negative value disables them
+	Retries int
+}
+
+// Tx is a database transaction on the master database
+type Tx interface {
+	Insert(ctx context.Context, query string, args ...interface{}) *Row
+	Query(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	Select(ctx context.Context, query string, args ...interface{}) *Row
+	Update(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Delete(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Write(ctx context.Context, query string, args ...interface{}) (*Rows, error)
+	// WithTx runs fn inside a savepoint of the transaction
+	WithTx(ctx context.Context, fn func(tx Tx) error) error
+	// Context returns a context carrying the transaction, so that
+	// nested Conn.WithTx calls join it through a savepoint
+	Context() context.Context
+	// Dialect returns the dialect of the database
+	Dialect() Dialect
+}
+
+// tx struct
+type tx struct {
+	master     *db
+	sqlTx      *sql.Tx
+	ctx        context.Context
+	savepoints *int
+}
+
+// TxFromContext returns the transaction carried by ctx
+func TxFromContext(ctx context.Context) (Tx, bool) {
+	t, ok := ctx.Value(txKey{}).(*tx)
+	return t, ok
+}
+
+// WithTx runs fn in a transaction on the master database. The transaction is
+// committed when fn returns nil and rolled back when it returns an error or
+// panics. When ctx already carries a transaction fn runs in a savepoint of it.
+// Serialization failures of the outermost transaction are retried.
+func (c *Conn) WithTx(ctx context.Context, opts *TxOptions, fn func(tx Tx) error) error {
+	if t, ok := TxFromContext(ctx); ok {
+		return t.WithTx(ctx, fn)
+	}
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	retries := opts.Retries
+	if retries == 0 {
+		retries = defaultRetries
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = c.runTx(ctx, opts, fn)
+		if err == nil || !isSerializationFailure(err) || attempt >= retries {
+			return err
+		}
+
+		// back off before retrying the conflicting transaction
+		backoff := time.Duration(1<<attempt)*10*time.Millisecond +
+			time.Duration(rand.Int63n(int64(10*time.Millisecond)))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// runTx runs a single attempt of a transaction
+func (c *Conn) runTx(ctx context.Context, opts *TxOptions, fn func(tx Tx) error) (err error) {
+	ctx, span := tracing.Start(ctx, "db.transaction")
+	defer func() { tracing.End(span, err) }()
+
+	sqlTx, err := c.master.conn.BeginTx(ctx, &sql.TxOptions{
+		Isolation: opts.Isolation,
+		ReadOnly:  opts.ReadOnly,
+	})
+	if err != nil {
+		return err
+	}
+	t := &tx{master: c.master, sqlTx: sqlTx, savepoints: new(int)}
+	t.ctx = context.WithValue(ctx, txKey{}, t)
+	if tenant, ok := TenantFromContext(ctx); ok && c.tenancy != nil {
+		if err = c.tenancy.apply(ctx, sqlTx, tenant, true); err != nil {
+			_ = sqlTx.Rollback()
+			return err
+		}
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(t); err != nil {
+		if rbErr := sqlTx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	if err = sqlTx.Commit(); err != nil {
+		return err
+	}
+	c.recordWrite(ctx)
+	return nil
+}
+
+// WithTx runs fn inside a savepoint of the transaction
+func (t *tx) WithTx(ctx context.Context, fn func(tx Tx) error) (err error) {
+	*t.savepoints++
+	savepoint := fmt.Sprintf("sp_%d", *t.savepoints)
+	if _, err = t.sqlTx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = t.sqlTx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint)
+			panic(p)
+		}
+	}()
+
+	if err = fn(t); err != nil {
+		if _, rbErr := t.sqlTx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); rbErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	_, err = t.sqlTx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint)
+	return err
+}
+
+// Context returns a context carrying the transaction
+func (t *tx) Context() context.Context {
+	return t.ctx
+}
+
+// Dialect returns the dialect of the database
+func (t *tx) Dialect() Dialect {
+	return t.master.dialect
+}
+
+// Insert method make a single row query in the transaction
+func (t *tx) Insert(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, call := t.master.start(ctx, "insert", query, args)
+	ctx, cancel := t.master.withTimeout(ctx)
+	row := t.sqlTx.QueryRowContext(ctx, query, args...)
+	call.end(row.Err())
+	return &Row{row: row, release: release(cancel, nil, nil)}
+}
+
+// Query method make a resultset rows query in the transaction
+func (t *tx) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return t.query(ctx, "query", query, args...)
+}
+
+// Write method runs a statement returning rows in the transaction
+func (t *tx) Write(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return t.query(ctx, "write", query, args...)
+}
+
+// query runs a statement returning rows in the transaction
+func (t *tx) query(ctx context.Context, operation, query string, args ...interface{}) (*Rows, error) {
+	ctx, call := t.master.start(ctx, operation, query, args)
+	ctx, cancel := t.master.withTimeout(ctx)
+	rows, err := t.sqlTx.QueryContext(ctx, query, args...)
+	if err != nil {
+		call.end(err)
+		cancel()
+		return nil, err
+	}
+	return &Rows{Rows: rows, release: release(cancel, nil, nil), call: call}, nil
+}
+
+// Select method make a single row query in the transaction
+func (t *tx) Select(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, call := t.master.start(ctx, "select", query, args)
+	ctx, cancel := t.master.withTimeout(ctx)
+	row := t.sqlTx.QueryRowContext(ctx, query, args...)
+	call.end(row.Err())
+	return &Row{row: row, release: release(cancel, nil, nil)}
+}
+
+// Update method executes update database changes in the transaction
+func (t *tx) Update(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.exec(ctx, "update", query, args...)
+}
+
+// Delete method executes delete database changes in the transaction
+func (t *tx) Delete(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.exec(ctx, "delete", query, args...)
+}
+
+// Exec method executes a statement in the transaction
+func (t *tx) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.exec(ctx, "exec", query, args...)
+}
+
+// exec executes a statement in the transaction
+func (t *tx) exec(ctx context.Context, operation, query string, args ...interface{}) (sql.Result, error) {
+	ctx, call := t.master.start(ctx, operation, query, args)
+	ctx, cancel := t.master.withTimeout(ctx)
+	defer cancel()
+	result, err := t.sqlTx.ExecContext(ctx, query, args...)
+	call.end(err)
+	return result, err
+}
+
+// isSerializationFailure checks if err is a Postgres serialization failure
+func isSerializationFailure(err error) bool {
+	var state interface{ SQLState() string }
+	return errors.As(err, &state) && state.SQLState() == serializationFailure
+}
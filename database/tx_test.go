@@ -0,0 +1,91 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/greatfocus/gf-sframe/database"
+)
+
+// names returns the names stored in the items table
+func names(t *testing.T, conn *database.Conn) []string {
+	t.Helper()
+	var names []string
+	rows, err := conn.Query(context.Background(), "SELECT name FROM items ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestWithTxNestedRollback(t *testing.T) {
+	conn := memory(t)
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx, "CREATE TABLE items (name TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("inner failed")
+	err := conn.WithTx(ctx, nil, func(tx database.Tx) error {
+		if _, err := tx.Exec(tx.Context(), "INSERT INTO items VALUES ('outer')"); err != nil {
+			return err
+		}
+		err := conn.WithTx(tx.Context(), nil, func(tx database.Tx) error {
+			if _, err := tx.Exec(tx.Context(), "INSERT INTO items VALUES ('inner')"); err != nil {
+				return err
+			}
+			return failed
+		})
+		if !errors.Is(err, failed) {
+			t.Errorf("nested transaction returned %v", err)
+		}
+		return conn.WithTx(tx.Context(), nil, func(tx database.Tx) error {
+			_, err := tx.Exec(tx.Context(), "INSERT INTO items VALUES ('sibling')")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(t, conn); len(got) != 2 || got[0] != "outer" || got[1] != "sibling" {
+		t.Fatalf("stored %v, want the outer and sibling rows", got)
+	}
+}
+
+func TestWithTxRollback(t *testing.T) {
+	conn := memory(t)
+	ctx := context.Background()
+	if _, err := conn.Exec(ctx, "CREATE TABLE items (name TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("outer failed")
+	err := conn.WithTx(ctx, nil, func(tx database.Tx) error {
+		err := conn.WithTx(tx.Context(), nil, func(tx database.Tx) error {
+			_, err := tx.Exec(tx.Context(), "INSERT INTO items VALUES ('inner')")
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("transaction returned %v", err)
+	}
+	if got := names(t, conn); len(got) != 0 {
+		t.Fatalf("stored %v after the outer rollback", got)
+	}
+}
@@ -1,15 +1,26 @@
 package frame
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"time"
 
-	gfcache "github.com/greatfocus/gf-cache"
+	gfbus "github.com/greatfocus/gf-bus"
 	gfcron "github.com/greatfocus/gf-cron"
 	gfdispatcher "github.com/greatfocus/gf-dispatcher"
+	"github.com/greatfocus/gf-sframe/audit"
+	"github.com/greatfocus/gf-sframe/cache"
 	"github.com/greatfocus/gf-sframe/config"
+	"github.com/greatfocus/gf-sframe/crypt"
 	"github.com/greatfocus/gf-sframe/database"
+	"github.com/greatfocus/gf-sframe/idempotency"
+	"github.com/greatfocus/gf-sframe/outbox"
 	"github.com/greatfocus/gf-sframe/server"
+	"github.com/greatfocus/gf-sframe/tracing"
 	gfvalidator "github.com/greatfocus/gf-validator"
 )
 
@@ -32,11 +43,14 @@ func (f *Frame) init(impl *config.Impl) *server.Meta {
 	// read the config file and prepare object
 	config := f.initConfig(impl)
 
+	// initTracing configures the tracer provider
+	provider := f.initTracing(config)
+
 	// initCron creates instance of cron
 	cron := f.initCron()
 
 	// initCache creates instance of cache
-	cache := f.initCache(config.Cache.DefaultExpiration, config.Cache.CleanupInterval)
+	cache := f.initCache(config.Cache)
 
 	// initDB create database connection
 	db := f.initDB(config, impl)
@@ -46,10 +60,38 @@ func (f *Frame) init(impl *config.Impl) *server.Meta {
 
 	dispatcher := f.initDispatcher(config)
 
+	// initLogger creates the structured logger
+	logger, level := f.initLogger(config)
+
+	// the slow query log uses the structured logger
+	db.SetLogger(logger)
+	cache.SetLogger(logger)
+
+	// initIPResolver creates the client ip resolver
+	ip := f.initIPResolver(config)
+
+	// initHealth creates the readiness checks
+	health := f.initHealth(config)
+
+	// initLeader campaigns for leadership of the service
+	leader := f.initLeader(config, db)
+
+	// initBus creates the event bus
+	bus := f.initBus()
+
+	// initClient creates the http client of the service
+	client := f.initClient(config)
+
+	// initOutbox creates the transactional outbox
+	outbox := f.initOutbox(config, db, bus, logger)
+
+	// initAudit creates the audit trail
+	audit := f.initAudit(config, db)
+
 	// Initiate validator
 	gfvalidator.SetFieldsRequiredByDefault(true)
 
-	return &server.Meta{
+	meta := &server.Meta{
 		Env:        impl.Env,
 		Config:     config,
 		Cron:       cron,
@@ -57,7 +99,31 @@ func (f *Frame) init(impl *config.Impl) *server.Meta {
 		DB:         db,
 		JWT:        jwt,
 		Dispatcher: dispatcher,
+		Logger:     logger,
+		LogLevel:   level,
+		IP:         ip,
+		Tracing:    provider,
+		Client:     client,
+		Health:     health,
+		Bus:        bus,
+		Outbox:     outbox,
+		Audit:      audit,
+		Leader:     leader,
 	}
+
+	// initListener listens on the configured database channels
+	meta.Listener = f.initListener(meta)
+
+	// initMetrics creates the metrics registry
+	meta.Metrics = f.initMetrics(meta)
+
+	// initCacheStore shares the cache between the instances
+	f.initCacheStore(meta)
+
+	// initIdempotency stores the responses of idempotent requests
+	meta.Idempotency = f.initIdempotency(meta)
+
+	return meta
 }
 
 // Start spins up the service
@@ -78,10 +144,46 @@ func (f *Frame) initCron() *gfcron.Cron {
 }
 
 // initCache creates instance of cache
-func (f *Frame) initCache(defaultExpiration, cleanupInterval int64) *gfcache.Cache {
-	// Create a cache with a default expiration time of 5 minutes, and which
-	// purges expired items every 10 minutes
-	return gfcache.New(time.Duration(defaultExpiration), time.Duration(cleanupInterval))
+func (f *Frame) initCache(cacheConfig config.Cache) *cache.Cache {
+	c := cache.New(cacheConfig.DefaultExpiration.Duration())
+	c.SetJitter(cacheConfig.Jitter)
+	c.SetNegativeExpiration(cacheConfig.NegativeExpiration.Duration())
+	if cacheConfig.Backend == "memory" {
+		c.SetStore(f.initMemoryStore(cacheConfig))
+	}
+	return c
+}
+
+// initMemoryStore creates the bounded in-process cache store
+func (f *Frame) initMemoryStore(cacheConfig config.Cache) *cache.Memory {
+	store, err := cache.NewMemory(int(cacheConfig.Capacity), cacheConfig.Eviction)
+	if err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	return store
+}
+
+// initCacheStore creates the shared cache store, the two-tier store listens
+// for the invalidations of the other instances
+func (f *Frame) initCacheStore(meta *server.Meta) {
+	cacheConfig := meta.Config.Cache
+	if cacheConfig.Backend == "memory" {
+		return
+	}
+	shared, err := cache.NewPostgres(context.Background(), meta.DB, cacheConfig.Table)
+	if err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	if cacheConfig.Backend == "postgres" {
+		meta.Cache.SetStore(shared)
+		return
+	}
+	local := f.initMemoryStore(cacheConfig)
+	tiered := cache.NewTwoTier(local, shared, meta.DB, cacheConfig.Channel, cacheConfig.LocalExpiration.Duration())
+	if err := tiered.Listen(meta.Listener); err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	meta.Cache.SetStore(tiered)
 }
 
 // initDB read the configuration file
@@ -104,3 +206,130 @@ func (f *Frame) initDispatcher(config *config.Config) *gfdispatcher.Disp {
 	d := gfdispatcher.NewDispatcher(int(config.Server.Workers)).Start()
 	return d
 }
+
+// initLogger creates the structured logger with an adjustable level
+func (f *Frame) initLogger(config *config.Config) (*slog.Logger, *slog.LevelVar) {
+	level := &slog.LevelVar{}
+	if config.Server.LogLevel != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(config.Server.LogLevel)); err != nil {
+			log.Fatal(fmt.Println(err))
+		}
+		level.Set(l)
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})), level
+}
+
+// initIPResolver creates the client ip resolver from trusted proxies
+func (f *Frame) initIPResolver(config *config.Config) *server.IPResolver {
+	ip, err := server.NewIPResolver(config.Server.TrustedProxies)
+	if err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	return ip
+}
+
+// initMetrics creates the metrics registry
+func (f *Frame) initMetrics(meta *server.Meta) *server.Metrics {
+	if !meta.Config.Server.Metrics.Enabled {
+		return nil
+	}
+	return server.NewMetrics(meta)
+}
+
+// initTracing configures the tracer provider
+func (f *Frame) initTracing(config *config.Config) *tracing.Provider {
+	if !config.Tracing.Enabled {
+		return nil
+	}
+	provider, err := tracing.Init(config.Tracing)
+	if err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	return provider
+}
+
+// initHealth creates the readiness checks
+func (f *Frame) initHealth(config *config.Config) *server.Health {
+	return server.NewHealth(config.Server.Health.Timeout.Duration())
+}
+
+// initBus creates the event bus
+func (f *Frame) initBus() gfbus.Bus {
+	return gfbus.New()
+}
+
+// initClient creates the http client calling other services and
+// integrations, its requests carry the trace context
+func (f *Frame) initClient(config *config.Config) *http.Client {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Env == "prod" {
+		base.TLSClientConfig = crypt.TLSClientConfig()
+	}
+	return &http.Client{
+		Timeout:   time.Duration(config.Server.Timeout) * time.Second,
+		Transport: tracing.Transport(base),
+	}
+}
+
+// initOutbox creates the transactional outbox
+func (f *Frame) initOutbox(config *config.Config, db *database.Conn, bus gfbus.Bus, logger *slog.Logger) *outbox.Outbox {
+	if !config.Outbox.Enabled {
+		return nil
+	}
+	o, err := outbox.New(context.Background(), db, bus, config.Outbox, logger)
+	if err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	return o
+}
+
+// initAudit creates the audit trail
+func (f *Frame) initAudit(config *config.Config, db *database.Conn) *audit.Audit {
+	if !config.Audit.Enabled {
+		return nil
+	}
+	a, err := audit.New(context.Background(), db, config.Audit)
+	if err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	return a
+}
+
+// initIdempotency creates the store of idempotency keys
+func (f *Frame) initIdempotency(meta *server.Meta) idempotency.Store {
+	cfg := meta.Config.Idempotency
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Store == "cache" {
+		return idempotency.NewCache(meta.Cache)
+	}
+	store, err := idempotency.NewDatabase(context.Background(), meta.DB, cfg.Table)
+	if err != nil {
+		log.Fatal(fmt.Println(err))
+	}
+	return store
+}
+
+// initListener creates the database listener, delivering notifications
+// through the dispatcher
+func (f *Frame) initListener(meta *server.Meta) *database.Listener {
+	listener := database.NewListener(meta.DB, meta.Dispatch)
+	for _, channel := range meta.Config.Database.Channels {
+		if err := listener.Listen(channel); err != nil {
+			log.Fatal(fmt.Println(err))
+		}
+	}
+	return listener
+}
+
+// initLeader creates the leader elector of the service
+func (f *Frame) initLeader(config *config.Config, db *database.Conn) *database.Elector {
+	if !config.Database.Leader.Enabled {
+		return nil
+	}
+	leader := database.NewElector(db, config.Database.Leader.Name, config.Database.Leader.TTL.Duration())
+	leader.Start()
+	return leader
+}
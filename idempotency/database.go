@@ -0,0 +1,147 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/database"
+)
+
+// record is an idempotency row
+type record struct {
+	Fingerprint string         `db:"fingerprint"`
+	Status      int            `db:"status"`
+	Header      sql.NullString `db:"header"`
+	Body        []byte         `db:"body"`
+	LockedUntil time.Time      `db:"locked_until"`
+}
+
+// Database is a store on a table, its claims are atomic across instances
+type Database struct {
+	conn  *database.Conn
+	table string
+}
+
+// NewDatabase creates the store and its table
+func NewDatabase(ctx context.Context, conn *database.Conn, table string) (*Database, error) {
+	d := &Database{conn: conn, table: table}
+	if err := d.createTable(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// createTable creates the idempotency table and its index
+func (d *Database) createTable(ctx context.Context) error {
+	table := database.QuoteIdentifier(d.table)
+	name := d.table[strings.LastIndex(d.table, ".")+1:]
+	dialect := d.conn.Dialect()
+	binary := "BLOB"
+	if dialect == database.Postgres {
+		binary = "BYTEA"
+	}
+	if _, err := d.conn.Exec(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			key TEXT PRIMARY KEY,
+			fingerprint TEXT NOT NULL,
+			status INTEGER NOT NULL DEFAULT 0,
+			header TEXT,
+			body %s,
+			locked_until %s NOT NULL,
+			expires_at %s NOT NULL,
+			token TEXT NOT NULL
+		)`, table, binary, dialect.Timestamp(), dialect.Timestamp())); err != nil {
+		return err
+	}
+	_, err := d.conn.Exec(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (expires_at)",
+		database.QuoteIdentifier(name+"_expires"), table))
+	return err
+}
+
+// Claim inserts key, or takes over a key whose lock timed out or which
+// expired, in a single statement
+func (d *Database) Claim(ctx context.Context, key, fingerprint string, lockTimeout, ttl time.Duration) (Record, bool, error) {
+	now := time.Now().UTC()
+	lockedUntil := now.Add(lockTimeout)
+	token := newToken()
+	rows, err := d.conn.Write(ctx, fmt.Sprintf(`
+		INSERT INTO %s AS i (key, fingerprint, status, locked_until, expires_at, token)
+		VALUES ($1, $2, 0, $3, $4, $6)
+		ON CONFLICT (key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint, status = 0, header = NULL, body = NULL,
+			locked_until = EXCLUDED.locked_until, expires_at = EXCLUDED.expires_at, token = EXCLUDED.token
+		WHERE (i.status = 0 AND i.locked_until < $5) OR i.expires_at < $5
+		RETURNING key`, database.QuoteIdentifier(d.table)),
+		key, fingerprint, lockedUntil, now.Add(ttl), now, token)
+	if err != nil {
+		return Record{}, false, err
+	}
+	claimed := rows.Next()
+	if err := rows.Close(); err != nil {
+		return Record{}, false, err
+	}
+	if err := rows.Err(); err != nil {
+		return Record{}, false, err
+	}
+	if claimed {
+		return Record{Fingerprint: fingerprint, LockedUntil: lockedUntil, Token: token}, true, nil
+	}
+
+	// read the holder of the key from the master, replicas may lag behind
+	r, err := database.QueryOne[record](database.WithPrimary(ctx), d.conn, fmt.Sprintf(
+		"SELECT fingerprint, status, header, body, locked_until FROM %s WHERE key = $1",
+		database.QuoteIdentifier(d.table)), key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// released between the two statements
+		return d.Claim(ctx, key, fingerprint, lockTimeout, ttl)
+	}
+	if err != nil {
+		return Record{}, false, err
+	}
+	stored := Record{Fingerprint: r.Fingerprint, Status: r.Status, Body: r.Body, LockedUntil: r.LockedUntil}
+	if r.Header.Valid {
+		if err := json.Unmarshal([]byte(r.Header.String), &stored.Header); err != nil {
+			return Record{}, false, err
+		}
+	}
+	return stored, false, nil
+}
+
+// Complete stores the response of key while r holds its claim
+func (d *Database) Complete(ctx context.Context, key string, r Record) error {
+	header, err := json.Marshal(r.Header)
+	if err != nil {
+		return err
+	}
+	_, err = database.Update(d.table).
+		Set("status", r.Status).
+		Set("header", string(header)).
+		Set("body", r.Body).
+		Where("key = ?", key).
+		Where("token = ?", r.Token).
+		Exec(ctx, d.conn)
+	return err
+}
+
+// Release deletes key while it is in flight under the claim with token
+func (d *Database) Release(ctx context.Context, key, token string) error {
+	_, err := database.Delete(d.table).
+		Where("key = ?", key).
+		Where("token = ?", token).
+		Where("status = 0").
+		Exec(ctx, d.conn)
+	return err
+}
+
+// Purge deletes the expired keys
+func (d *Database) Purge(ctx context.Context) error {
+	_, err := database.Delete(d.table).
+		Where("expires_at < ?", time.Now().UTC()).
+		Exec(ctx, d.conn)
+	return err
+}
@@ -0,0 +1,118 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/cache"
+)
+
+// Record is the state of an idempotency key: in flight until Status is set,
+// then the response replayed to the retries. Token identifies the claim and
+// is only returned to its holder.
+type Record struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	LockedUntil time.Time   `json:"lockedUntil"`
+	Token       string      `json:"token,omitempty"`
+}
+
+// InFlight checks if the first request of the key has not completed
+func (r Record) InFlight() bool {
+	return r.Status == 0
+}
+
+// Store keeps the idempotency keys and the responses of their requests
+type Store interface {
+	// Claim locks key for the request with fingerprint until lockTimeout,
+	// it returns the record of key and false when another request holds
+	// the lock or has completed. Keys expire after ttl.
+	Claim(ctx context.Context, key, fingerprint string, lockTimeout, ttl time.Duration) (Record, bool, error)
+	// Complete stores the response of the request holding key, unless the
+	// claim with the token of r was taken over meanwhile
+	Complete(ctx context.Context, key string, r Record) error
+	// Release unlocks a key whose request failed without a response, unless
+	// the claim with token was taken over meanwhile
+	Release(ctx context.Context, key, token string) error
+}
+
+// Cache is a store on the cache. Claims are atomic within an instance only,
+// the database store is needed for instances behind a load balancer.
+type Cache struct {
+	cache *cache.Cache
+	mu    sync.Mutex
+}
+
+// NewCache creates a store on c
+func NewCache(c *cache.Cache) *Cache {
+	return &Cache{cache: c}
+}
+
+// Claim locks key unless it is held or completed
+func (s *Cache) Claim(ctx context.Context, key, fingerprint string, lockTimeout, ttl time.Duration) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.key(key)
+	if r, found := cache.GetValue[Record](ctx, s.cache, k); found && (!r.InFlight() || time.Now().Before(r.LockedUntil)) {
+		r.Token = ""
+		return r, false, nil
+	}
+	r := Record{Fingerprint: fingerprint, LockedUntil: time.Now().Add(lockTimeout), Token: newToken()}
+	if err := s.cache.SetValue(ctx, k, r, ttl); err != nil {
+		return Record{}, false, err
+	}
+	return r, true, nil
+}
+
+// Complete stores the response of key, keeping the expiration of the claim
+func (s *Cache) Complete(ctx context.Context, key string, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.key(key)
+	if held, found := cache.GetValue[Record](ctx, s.cache, k); !found || held.Token != r.Token {
+		return nil
+	}
+	item, found, err := s.cache.Store().Get(ctx, k)
+	if err != nil || !found {
+		return err
+	}
+	// a zero ttl is the default expiration and a negative one never expires,
+	// so a claim that expired meanwhile is not stored again
+	ttl := time.Duration(-1)
+	if !item.Expiration.IsZero() {
+		ttl = time.Until(item.Expiration)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+	return s.cache.SetValue(ctx, k, r, ttl)
+}
+
+// Release deletes the claim of key
+func (s *Cache) Release(ctx context.Context, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.key(key)
+	if held, found := cache.GetValue[Record](ctx, s.cache, k); !found || held.Token != token || !held.InFlight() {
+		return nil
+	}
+	return s.cache.Evict(ctx, k)
+}
+
+// key returns the cache key of an idempotency key
+func (s *Cache) key(key string) string {
+	return "idempotency:" + key
+}
+
+// newToken returns a random claim token
+func newToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,348 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	gfbus "github.com/greatfocus/gf-bus"
+	"github.com/greatfocus/gf-sframe/config"
+	"github.com/greatfocus/gf-sframe/database"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// retryDelay is the delay before the first retry, doubled on every attempt
+const retryDelay = 30 * time.Second
+
+// maxRetryDelay caps the delay between retries
+const maxRetryDelay = time.Hour
+
+// Event is a message stored in the outbox
+type Event struct {
+	ID        int64             `json:"id"`
+	Aggregate string            `json:"aggregate"`
+	Topic     string            `json:"topic"`
+	Payload   json.RawMessage   `json:"payload"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Attempts  int64             `json:"attempts"`
+}
+
+// record is an outbox row
+type record struct {
+	ID        int64     `db:"id"`
+	Aggregate string    `db:"aggregate"`
+	Topic     string    `db:"topic"`
+	Payload   []byte    `db:"payload"`
+	Headers   []byte    `db:"headers"`
+	CreatedAt time.Time `db:"created_at"`
+	Attempts  int64     `db:"attempts"`
+}
+
+// Outbox stores events in the transaction of the business data and relays
+// them to the bus and webhooks afterwards. Delivery is at least once and in
+// order per aggregate, so consumers should ignore event ids they have seen.
+type Outbox struct {
+	conn        *database.Conn
+	bus         gfbus.Bus
+	table       string
+	batchSize   int64
+	maxAttempts int64
+	lease       time.Duration
+	webhooks    []*webhook
+	logger      *slog.Logger
+}
+
+// New creates the outbox and migrates its table
+func New(ctx context.Context, conn *database.Conn, bus gfbus.Bus, cfg config.Outbox, logger *slog.Logger) (*Outbox, error) {
+	if conn.Dialect() != database.Postgres {
+		return nil, fmt.Errorf("outbox on %s: %w", conn.Dialect().Name(), database.ErrUnsupported)
+	}
+	o := &Outbox{
+		conn:        conn,
+		bus:         bus,
+		table:       cfg.Table,
+		batchSize:   cfg.BatchSize,
+		maxAttempts: cfg.MaxAttempts,
+		lease:       cfg.Lease.Duration(),
+		logger:      logger,
+	}
+	for _, w := range cfg.Webhooks {
+		o.webhooks = append(o.webhooks, newWebhook(w))
+	}
+
+	// the versions of the outbox are tracked apart from the service's
+	migrator, err := database.NewMigrator(conn, Migrations(o.table))
+	if err != nil {
+		return nil, err
+	}
+	migrator.Table = o.table + "_migrations"
+	if _, err := migrator.Up(ctx); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Migrations returns the migrations of the outbox table
+func Migrations(table string) []database.Migration {
+	name := table[strings.LastIndex(table, ".")+1:]
+	quoted := database.QuoteIdentifier(table)
+	return []database.Migration{
+		{
+			Version: 1,
+			Name:    "create_outbox",
+			Up: fmt.Sprintf(`
+				CREATE TABLE IF NOT EXISTS %s (
+					id BIGSERIAL PRIMARY KEY,
+					aggregate TEXT NOT NULL,
+					topic TEXT NOT NULL,
+					payload JSONB NOT NULL,
+					headers JSONB NOT NULL DEFAULT '{}',
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					attempts INT NOT NULL DEFAULT 0,
+					next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					last_error TEXT,
+					delivered_at TIMESTAMPTZ,
+					failed_at TIMESTAMPTZ
+				);
+				CREATE INDEX IF NOT EXISTS %s ON %s (aggregate, id)
+					WHERE delivered_at IS NULL AND failed_at IS NULL`,
+				quoted, database.QuoteIdentifier(name+"_pending"), quoted),
+			Down: "DROP TABLE " + quoted,
+		},
+		{
+			Version: 2,
+			Name:    "add_outbox_lease",
+			Up:      "ALTER TABLE " + quoted + " ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ",
+			Down:    "ALTER TABLE " + quoted + " DROP COLUMN locked_until",
+		},
+	}
+}
+
+// Publish stores an event in tx, it is delivered once tx commits
+func (o *Outbox) Publish(ctx context.Context, tx database.Tx, aggregate, topic string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	// carry the trace of the publisher over to the delivery
+	headers := make(map[string]string)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.Insert(o.table).
+		Columns("aggregate", "topic", "payload", "headers").
+		Values(aggregate, topic, string(body), string(encoded)).
+		Exec(ctx, tx)
+	return err
+}
+
+// Relay delivers the due events in batches until none are left, it is
+// scheduled on the cron table
+func (o *Outbox) Relay(ctx context.Context) {
+	for ctx.Err() == nil {
+		n, err := o.relayBatch(ctx)
+		if err != nil {
+			o.logger.Error("outbox relay failed", "error", err)
+			return
+		}
+		if n < o.batchSize {
+			return
+		}
+	}
+}
+
+// relayBatch claims a batch of due events, delivers it outside of any
+// transaction and records the outcomes, it returns the size of the batch
+func (o *Outbox) relayBatch(ctx context.Context) (int64, error) {
+	records, err := o.claim(ctx)
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+
+	errs := make([]error, len(records))
+	skipped := make([]bool, len(records))
+	blocked := make(map[string]bool)
+	renewed := time.Now()
+	var leaseErr error
+	for i, r := range records {
+		if leaseErr != nil {
+			// another instance may claim the events once the lease lapses
+			skipped[i] = true
+			continue
+		}
+		if time.Since(renewed) > o.lease/2 {
+			// the lease is extended before half of it is spent
+			if leaseErr = o.extend(ctx, records[i:]); leaseErr != nil {
+				skipped[i] = true
+				continue
+			}
+			renewed = time.Now()
+		}
+		if blocked[r.Aggregate] {
+			// stays pending behind the failed event of its aggregate
+			skipped[i] = true
+			continue
+		}
+		event, err := r.event()
+		if err == nil {
+			err = o.deliver(ctx, event)
+		}
+		if err != nil {
+			blocked[r.Aggregate] = true
+		}
+		errs[i] = err
+	}
+
+	err = o.conn.WithTx(context.WithoutCancel(ctx), nil, func(tx database.Tx) error {
+		for i, r := range records {
+			if err := o.record(tx.Context(), tx, r, errs[i], skipped[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if leaseErr != nil {
+		return int64(len(records)), fmt.Errorf("outbox lease: %w", leaseErr)
+	}
+	return int64(len(records)), err
+}
+
+// extend renews the lease of the events left to deliver
+func (o *Outbox) extend(ctx context.Context, records []record) error {
+	ids := make([]int64, len(records))
+	for i, r := range records {
+		ids[i] = r.ID
+	}
+	_, err := o.conn.Update(ctx, fmt.Sprintf(
+		"UPDATE %s SET locked_until = now() + $1 * interval '1 second' WHERE id = ANY($2)",
+		database.QuoteIdentifier(o.table)), o.lease.Seconds(), pq.Array(ids))
+	return err
+}
+
+// claim leases the due events in a short transaction. Events of an
+// aggregate are skipped while an earlier event of it waits for a retry or
+// is leased, and claims are serialized so that the check sees every lease.
+func (o *Outbox) claim(ctx context.Context) ([]record, error) {
+	var records []record
+	err := o.conn.WithTx(ctx, nil, func(tx database.Tx) error {
+		ctx := tx.Context()
+		if err := database.XactLock(ctx, tx, "outbox:"+o.table); err != nil {
+			return err
+		}
+
+		table := database.QuoteIdentifier(o.table)
+		var err error
+		records, err = database.QueryStructs[record](ctx, database.Writer(tx), fmt.Sprintf(`
+			UPDATE %[1]s SET locked_until = now() + $1 * interval '1 second'
+			WHERE id IN (
+				SELECT o.id FROM %[1]s o
+				WHERE o.delivered_at IS NULL AND o.failed_at IS NULL AND o.next_attempt_at <= now()
+				AND (o.locked_until IS NULL OR o.locked_until <= now())
+				AND NOT EXISTS (
+					SELECT 1 FROM %[1]s p
+					WHERE p.aggregate = o.aggregate AND p.id < o.id
+					AND p.delivered_at IS NULL AND p.failed_at IS NULL
+					AND (p.next_attempt_at > now() OR p.locked_until > now())
+				)
+				ORDER BY o.id
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, aggregate, topic, payload, headers, created_at, attempts`, table),
+			o.lease.Seconds(), o.batchSize)
+		return err
+	})
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, err
+}
+
+// record stores the outcome of a delivery attempt and ends the lease,
+// skipped events are left pending without an attempt
+func (o *Outbox) record(ctx context.Context, tx database.Tx, r record, deliveryErr error, skipped bool) error {
+	update := database.Update(o.table).Set("locked_until", nil).Where("id = ?", r.ID)
+	switch {
+	case skipped:
+	case deliveryErr == nil:
+		update.Set("delivered_at", time.Now())
+	case r.Attempts+1 >= o.maxAttempts:
+		o.logger.Error("outbox event failed", "id", r.ID, "topic", r.Topic,
+			"aggregate", r.Aggregate, "attempts", r.Attempts+1, "error", deliveryErr)
+		update.Set("attempts", r.Attempts+1).
+			Set("last_error", deliveryErr.Error()).
+			Set("failed_at", time.Now())
+	default:
+		o.logger.Warn("outbox event delivery failed", "id", r.ID, "topic", r.Topic,
+			"aggregate", r.Aggregate, "attempts", r.Attempts+1, "error", deliveryErr)
+		update.Set("attempts", r.Attempts+1).
+			Set("last_error", deliveryErr.Error()).
+			Set("next_attempt_at", time.Now().Add(backoff(r.Attempts+1)))
+	}
+	_, err := update.Exec(ctx, tx)
+	return err
+}
+
+// deliver sends the event to the bus subscribers and matching webhooks
+func (o *Outbox) deliver(ctx context.Context, event Event) error {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(event.Headers))
+	if o.bus != nil && o.bus.HasCallback(event.Topic) {
+		if err := o.publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	for _, w := range o.webhooks {
+		if w.matches(event.Topic) {
+			if err := w.send(ctx, event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// publish calls the bus subscribers of the topic with the context and the
+// event, subscribers should be synchronous for delivery to be confirmed
+func (o *Outbox) publish(ctx context.Context, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("bus subscriber of %s panicked: %v", event.Topic, r)
+		}
+	}()
+	o.bus.Publish(event.Topic, ctx, event)
+	return nil
+}
+
+// event decodes the record
+func (r record) event() (Event, error) {
+	event := Event{
+		ID:        r.ID,
+		Aggregate: r.Aggregate,
+		Topic:     r.Topic,
+		Payload:   json.RawMessage(r.Payload),
+		CreatedAt: r.CreatedAt,
+		Attempts:  r.Attempts + 1,
+	}
+	if err := json.Unmarshal(r.Headers, &event.Headers); err != nil {
+		return event, err
+	}
+	return event, nil
+}
+
+// backoff returns the delay before the next attempt
+func backoff(attempts int64) time.Duration {
+	delay := retryDelay
+	for i := int64(1); i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
@@ -0,0 +1,75 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/greatfocus/gf-sframe/config"
+	"github.com/greatfocus/gf-sframe/tracing"
+)
+
+// webhook delivers events of a topic over http
+type webhook struct {
+	topic  string
+	url    string
+	secret string
+	client *http.Client
+}
+
+// newWebhook creates a webhook from config
+func newWebhook(cfg config.Webhook) *webhook {
+	return &webhook{
+		topic:  cfg.Topic,
+		url:    cfg.URL,
+		secret: cfg.Secret,
+		client: &http.Client{
+			Timeout:   cfg.Timeout.Duration(),
+			Transport: tracing.Transport(nil),
+		},
+	}
+}
+
+// matches checks if the webhook receives topic, * receives every topic
+func (w *webhook) matches(topic string) bool {
+	return w.topic == "*" || w.topic == topic
+}
+
+// send posts the event and expects a 2xx response. The body is signed with
+// the secret in the X-Signature header as sha256=<hex hmac>.
+func (w *webhook) send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", strconv.FormatInt(event.ID, 10))
+	req.Header.Set("X-Event-Topic", event.Topic)
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded %s", w.url, resp.Status)
+	}
+	return nil
+}
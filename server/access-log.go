@@ -0,0 +1,111 @@
+package server
+
+import (
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/database"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// redacted replaces sensitive values in the access log
+const redacted = "REDACTED"
+
+// AccessLog records every request in structured format
+func AccessLog(meta *Meta) Middleware {
+	// the jwt parameter accepted by extractToken is always redacted
+	redact := map[string]bool{"jwt": true}
+	for _, param := range meta.Config.Server.AccessLog.RedactParams {
+		redact[strings.ToLower(param)] = true
+	}
+	sampleRate := meta.Config.Server.AccessLog.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww, rw := wrapWriter(w)
+
+			// continue
+			h.ServeHTTP(ww, r)
+
+			// server errors are always logged, the rest are sampled
+			status := rw.Status()
+			if status < http.StatusInternalServerError && sampleRate < 1 && rand.Float64() >= sampleRate {
+				return
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("route", meta.route(r)),
+				slog.String("path", r.URL.Path),
+				slog.String("query", redactQuery(r.URL.RawQuery, redact)),
+				slog.Int("status", status),
+				slog.Int64("bytes", rw.bytes),
+				slog.Duration("latency", time.Since(start)),
+				slog.String("ip", meta.IP.Resolve(r)),
+			}
+			if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+				attrs = append(attrs, slog.String("traceID", sc.TraceID().String()))
+			}
+			if meta.JWT != nil {
+				if userID, ok := meta.JWT.getUserID(r); ok {
+					attrs = append(attrs, slog.Int64("userID", userID))
+				}
+			}
+
+			level := slog.LevelInfo
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case status >= http.StatusBadRequest:
+				level = slog.LevelWarn
+			}
+			meta.Logger.LogAttrs(r.Context(), level, "access", attrs...)
+		})
+	}
+}
+
+// route returns the pattern registered on the mux for the request
+func (m *Meta) route(r *http.Request) string {
+	if m.Mux == nil {
+		return ""
+	}
+	_, pattern := m.Mux.Handler(r)
+	return pattern
+}
+
+// QueryCaller attributes the database queries of a request to its route
+func QueryCaller(meta *Meta) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := database.WithCaller(r.Context(), r.Method+" "+meta.route(r))
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// redactQuery masks the values of sensitive query parameters
+func redactQuery(rawQuery string, redact map[string]bool) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redacted
+	}
+	for key := range values {
+		if redact[strings.ToLower(key)] {
+			for k := range values[key] {
+				values[key][k] = redacted
+			}
+		}
+	}
+	return values.Encode()
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// secretKeys are config keys whose values are redacted
+var secretKeys = []string{"password", "secret", "key", "token"}
+
+// setAdmin creates the admin server on its own port
+func (m *Meta) setAdmin() {
+	if !m.Config.Admin.Enabled {
+		return
+	}
+
+	mux := http.NewServeMux()
+	handleProfiles(mux)
+	mux.HandleFunc("/admin/config", m.adminConfig)
+	mux.HandleFunc("/admin/log-level", m.adminLogLevel)
+	mux.HandleFunc("/admin/cron", m.adminCron)
+	mux.HandleFunc("/admin/dispatcher", m.adminDispatcher)
+	mux.HandleFunc("/admin/queries", m.adminQueries)
+	mux.HandleFunc("/admin/audit", m.adminAudit)
+	mux.HandleFunc("/admin/audit/verify", m.adminAuditVerify)
+
+	m.admin = &http.Server{
+		Addr:           ":" + m.Config.Admin.Port,
+		ReadTimeout:    time.Duration(m.Config.Server.Timeout) * time.Second,
+		WriteTimeout:   time.Duration(m.Config.Server.Timeout) * time.Second,
+		MaxHeaderBytes: 1 << 20,
+		Handler:        Use(mux, CheckAdmin(m)),
+	}
+	go func() {
+		log.Println("Listening to admin port HTTP", m.admin.Addr)
+		if err := m.admin.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// CheckAdmin validates the admin credentials and allowed IPs
+func CheckAdmin(meta *Meta) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			admin := meta.Config.Admin
+
+			// check if ip is available in list
+			if len(admin.AllowedIPs) > 0 {
+				allowed := false
+				ip := meta.IP.Resolve(r)
+				for _, v := range admin.AllowedIPs {
+					if v == ip {
+						allowed = true
+					}
+				}
+				if !allowed {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+
+			// check basic auth credentials
+			if admin.User != "" {
+				user, password, ok := r.BasicAuth()
+				if !ok ||
+					subtle.ConstantTimeCompare([]byte(user), []byte(admin.User)) != 1 ||
+					subtle.ConstantTimeCompare([]byte(password), []byte(admin.Password)) != 1 {
+					w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+			}
+
+			// continue
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// adminConfig returns the runtime config with secrets redacted
+func (m *Meta) adminConfig(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(m.Config)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	var config interface{}
+	_ = json.Unmarshal(body, &config)
+	writeJSON(w, http.StatusOK, redactSecrets(config))
+}
+
+// adminLogLevel reads or changes the log level
+func (m *Meta) adminLogLevel(w http.ResponseWriter, r *http.Request) {
+	var level struct {
+		Level string `json:"level"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&level); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level.Level)); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		m.LogLevel.Set(l)
+		m.Logger.Info("log level changed", "level", l.String())
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	level.Level = m.LogLevel.Level().String()
+	writeJSON(w, http.StatusOK, level)
+}
+
+// adminCron returns the status of the scheduled jobs
+func (m *Meta) adminCron(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.crons.list())
+}
+
+// adminQueries returns the query statistics, DELETE resets them
+func (m *Meta) adminQueries(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, m.DB.QueryStats())
+	case http.MethodDelete:
+		m.DB.ResetQueryStats()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// adminDispatcher returns the dispatcher load
+func (m *Meta) adminDispatcher(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.dispatcherStatus())
+}
+
+// redactSecrets masks values whose key looks like a secret
+func redactSecrets(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if isSecret(key) {
+				if s, ok := item.(string); ok && s == "" {
+					continue
+				}
+				value[key] = redacted
+				continue
+			}
+			value[key] = redactSecrets(item)
+		}
+	case []interface{}:
+		for k, item := range value {
+			value[k] = redactSecrets(item)
+		}
+	}
+	return v
+}
+
+// isSecret checks if the config key holds a secret
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON returns v as plain json
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/greatfocus/gf-sframe/audit"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the id of a request across services
+const requestIDHeader = "X-Request-ID"
+
+// AuditContext attributes the audit entries recorded while serving a request
+// to its caller: the user of the jwt, the request id and the client ip. The
+// request id is taken from the X-Request-ID header, or the trace, and is
+// echoed in the response.
+func AuditContext(meta *Meta) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := audit.Request{
+				RequestID: requestID(r),
+				IP:        meta.IP.Resolve(r),
+			}
+			if meta.JWT != nil {
+				// the token is decoded once for both claims
+				if claims, ok := meta.JWT.claims(r); ok {
+					if userID, ok := userIDClaim(claims); ok {
+						req.ActorID = userID
+						req.ActorRole, _ = stringClaim(claims, "role")
+					}
+				}
+			}
+			w.Header().Set(requestIDHeader, req.RequestID)
+
+			// continue
+			h.ServeHTTP(w, r.WithContext(audit.WithRequest(r.Context(), req)))
+		})
+	}
+}
+
+// Audited records a privileged action on resource once the handler returns,
+// whatever its outcome. The resource id is the {id} wildcard of the route.
+func Audited(meta *Meta, action, resource string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww, rw := wrapWriter(w)
+
+			// continue
+			h.ServeHTTP(ww, r)
+
+			if meta.Audit == nil {
+				return
+			}
+			err := meta.Audit.Record(r.Context(), audit.Entry{
+				Action:     action,
+				Resource:   resource,
+				ResourceID: r.PathValue("id"),
+				Metadata: map[string]interface{}{
+					"method": r.Method,
+					"path":   r.URL.Path,
+					"status": rw.Status(),
+				},
+			})
+			if err != nil {
+				meta.Logger.Error("audit record failed", "action", action, "resource", resource, "error", err)
+			}
+		})
+	}
+}
+
+// requestID returns the id of the request, from its header or trace, or a
+// new random one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= 128 {
+		return id
+	}
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		return sc.TraceID().String()
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// adminAudit returns the audit entries matching the query parameters
+func (m *Meta) adminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if m.Audit == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "audit is disabled"})
+		return
+	}
+	filter, err := audit.ParseFilter(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	entries, err := m.Audit.Query(r.Context(), filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// adminAuditVerify checks the hash chain of the audit trail
+func (m *Meta) adminAuditVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if m.Audit == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "audit is disabled"})
+		return
+	}
+	checked, err := m.Audit.Verify(r.Context())
+	switch {
+	case errors.Is(err, audit.ErrTampered):
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"checked": checked, "error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"checked": checked})
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/cache"
+	"github.com/greatfocus/gf-sframe/database"
+)
+
+// maxCachedBody is the largest response body stored by Cached
+const maxCachedBody = 1 << 20
+
+// cachedResponse is a response stored by Cached
+type cachedResponse struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"storedAt"`
+}
+
+// Cached serves GET and HEAD requests from the cache, keyed by path, query,
+// user and tenant. Successful responses are stored for d, or the max-age of
+// their Cache-Control header, unless they are marked no-store, no-cache,
+// set cookies or vary on request headers, which are not part of the key.
+// Requests with no-cache bypass the stored response and no-store bypass the
+// cache entirely. Tags may name path wildcards, such as "user:{id}", and
+// are invalidated with meta.Cache.Invalidate. Responses are kept in the
+// store of the cache, shared by the instances when it is.
+func Cached(meta *Meta, d time.Duration, tags ...string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if meta.Cache == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			directives := cacheControl(r.Header.Get("Cache-Control"))
+			if _, ok := directives["no-store"]; ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			userID, authenticated := int64(0), false
+			if meta.JWT != nil {
+				userID, authenticated = meta.JWT.getUserID(r)
+			}
+			key := meta.cacheKey(r, userID)
+			if _, ok := directives["no-cache"]; !ok && directives["max-age"] != "0" {
+				if cached, found := cache.GetValue[cachedResponse](r.Context(), meta.Cache, key); found {
+					cached.write(w, r)
+					return
+				}
+			}
+
+			cw := &cacheWriter{ResponseWriter: w, limit: maxCachedBody}
+			w.Header().Set("X-Cache", "MISS")
+			outer := w.Header().Clone()
+
+			// continue
+			h.ServeHTTP(cw, r)
+
+			// HEAD responses have no body to serve GET requests with
+			ttl, ok := storable(cw, d, authenticated)
+			if !ok || r.Method == http.MethodHead {
+				return
+			}
+			expanded := make([]string, len(tags))
+			for i, tag := range tags {
+				expanded[i] = expandTag(tag, r)
+			}
+			err := meta.Cache.SetValue(r.Context(), key, cachedResponse{
+				Status:   cw.Status(),
+				Header:   handlerHeader(w.Header(), outer),
+				Body:     cw.body.Bytes(),
+				StoredAt: time.Now(),
+			}, ttl, expanded...)
+			if err != nil {
+				meta.Logger.Warn("response cache failed", "key", key, "error", err)
+			}
+		})
+	}
+}
+
+// cacheKey returns the cache key of a request
+func (m *Meta) cacheKey(r *http.Request, userID int64) string {
+	var sb strings.Builder
+	sb.WriteString("http:")
+	sb.WriteString(r.URL.Path)
+	sb.WriteString("?")
+	// Encode sorts the parameters, so their order does not matter
+	sb.WriteString(r.URL.Query().Encode())
+	sb.WriteString("#user=")
+	sb.WriteString(strconv.FormatInt(userID, 10))
+	if tenant, ok := database.TenantFromContext(r.Context()); ok {
+		sb.WriteString("#tenant=")
+		sb.WriteString(tenant)
+	}
+	return sb.String()
+}
+
+// storable returns the expiration of a response, or false when it must not
+// be cached
+func storable(cw *cacheWriter, d time.Duration, authenticated bool) (time.Duration, bool) {
+	if cw.Status() != http.StatusOK || cw.overflow || cw.Header().Get("Set-Cookie") != "" ||
+		cw.Header().Get("Vary") != "" {
+		return 0, false
+	}
+	directives := cacheControl(cw.Header().Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache"} {
+		if _, ok := directives[directive]; ok {
+			return 0, false
+		}
+	}
+	// private responses are only stored under the key of their user
+	if _, ok := directives["private"]; ok && !authenticated {
+		return 0, false
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[directive]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return d, true
+}
+
+// handlerHeader returns the header fields set by the handler, leaving out
+// those of outer middlewares such as the request id
+func handlerHeader(header, outer http.Header) http.Header {
+	set := make(http.Header)
+	for k, v := range header {
+		if !slices.Equal(v, outer[k]) {
+			set[k] = slices.Clone(v)
+		}
+	}
+	return set
+}
+
+// write serves the stored response
+func (c *cachedResponse) write(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	for k, v := range c.Header {
+		header[k] = v
+	}
+	header.Set("X-Cache", "HIT")
+	header.Set("Age", strconv.Itoa(int(time.Since(c.StoredAt).Seconds())))
+	w.WriteHeader(c.Status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(c.Body)
+	}
+}
+
+// cacheControl parses the directives of a Cache-Control header
+func cacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// expandTag replaces the {name} wildcards of tag with the path values of r
+func expandTag(tag string, r *http.Request) string {
+	for {
+		start := strings.IndexByte(tag, '{')
+		end := strings.IndexByte(tag, '}')
+		if start < 0 || end < start {
+			return tag
+		}
+		name := tag[start+1 : end]
+		tag = tag[:start] + url.PathEscape(r.PathValue(name)) + tag[end+1:]
+	}
+}
+
+// cacheWriter copies the response body while writing it, up to limit
+// bytes unless it is zero
+type cacheWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+// WriteHeader records the status code
+func (cw *cacheWriter) WriteHeader(code int) {
+	if cw.status == 0 {
+		cw.status = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// Write copies b until the body is too large to be stored
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.overflow {
+		if cw.limit > 0 && cw.body.Len()+len(b) > cw.limit {
+			cw.overflow = true
+			cw.body.Reset()
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the original writer
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Status returns the recorded status code
+func (cw *cacheWriter) Status() int {
+	if cw.status == 0 {
+		return http.StatusOK
+	}
+	return cw.status
+}
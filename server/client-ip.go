@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPResolver resolves the client IP of a request, honouring
+// X-Forwarded-For only when the request came through a trusted proxy
+type IPResolver struct {
+	trusted []*net.IPNet
+}
+
+// NewIPResolver creates a resolver from a list of trusted proxy IPs or CIDRs
+func NewIPResolver(proxies []string) (*IPResolver, error) {
+	i := &IPResolver{}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", proxy, err)
+		}
+		i.trusted = append(i.trusted, network)
+	}
+	return i, nil
+}
+
+// Resolve returns the client IP address of the request
+func (i *IPResolver) Resolve(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !i.isTrusted(remote) {
+		return remote
+	}
+
+	// walk the forwarded chain from the closest hop and stop at
+	// the first address that is not one of our proxies
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for k := len(forwarded) - 1; k >= 0; k-- {
+		ip := strings.TrimSpace(forwarded[k])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		if !i.isTrusted(ip) {
+			return ip
+		}
+		remote = ip
+	}
+	return remote
+}
+
+// Secure checks if the client reached the service over TLS, directly or
+// through a trusted proxy setting X-Forwarded-Proto
+func (i *IPResolver) Secure(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	return i.isTrusted(remote) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// isTrusted checks if the ip belongs to a trusted proxy
+func (i *IPResolver) isTrusted(ip string) bool {
+	netIP := net.ParseIP(ip)
+	if netIP == nil {
+		return false
+	}
+	for _, network := range i.trusted {
+		if network.Contains(netIP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxTaggedBody is the largest response body buffered by ETags, larger
+// responses are passed through untagged
+const maxTaggedBody = 1 << 20
+
+// StrongETag returns an entity tag of the bytes of a representation
+func StrongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// WeakETag returns an entity tag of the content of a representation whose
+// bytes vary. It reveals the content to guesses, so encrypted responses use
+// ConditionalSuccess instead.
+func WeakETag(data []byte) string {
+	return "W/" + StrongETag(data)
+}
+
+// VersionETag returns an entity tag of the version column of a record, for
+// optimistic concurrency with If-Match. Check updates with
+// CheckPreconditions, and send the tag with CheckNotModified when reading
+// the record: If-Match uses the strong comparison, which the weak tags of
+// ConditionalSuccess never pass.
+func VersionETag(version interface{}) string {
+	return fmt.Sprintf(`"v%v"`, version)
+}
+
+// CheckNotModified sets the ETag and Last-Modified headers of a GET or HEAD
+// response, each when not empty, and writes 304 Not Modified when the
+// If-None-Match or If-Modified-Since header of the request still holds. It
+// returns true when the response is complete.
+func CheckNotModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	setValidators(w, etag, modified)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" || !matchETag(inm, etag, false) {
+			return false
+		}
+	} else if !notModifiedSince(r.Header.Get("If-Modified-Since"), modified) {
+		return false
+	}
+
+	// a 304 carries the validators but no content
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// CheckPreconditions evaluates the If-Match, If-Unmodified-Since and
+// If-None-Match headers of an update against the current etag and
+// modification time of the resource, an empty etag meaning it does not
+// exist. It writes 412 Precondition Failed and returns false when one fails.
+func CheckPreconditions(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	ok := true
+	if im := r.Header.Get("If-Match"); im != "" {
+		ok = etag != "" && matchETag(im, etag, true)
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ius)
+		ok = err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	if inm := r.Header.Get("If-None-Match"); ok && inm != "" && etag != "" {
+		ok = !matchETag(inm, etag, false)
+	}
+	if !ok {
+		w.WriteHeader(http.StatusPreconditionFailed)
+	}
+	return ok
+}
+
+// ConditionalSuccess writes data in the encrypted Response envelope, or 304
+// Not Modified. The envelope differs on every response, so its entity tag
+// is a weak tag of data, which never matches If-Match; see VersionETag.
+// The tag is keyed with the encryption key, so that it does not reveal data.
+func ConditionalSuccess(w http.ResponseWriter, r *http.Request, statusCode int, data string, modified time.Time) {
+	if CheckNotModified(w, r, keyedETag([]byte(os.Args[4]), []byte(data)), modified) {
+		return
+	}
+	Success(w, statusCode, data)
+}
+
+// keyedETag returns a weak entity tag of the HMAC of data
+func keyedETag(key, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return `W/"` + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16]) + `"`
+}
+
+// ConditionalJSON writes v as plain JSON with a strong entity tag, or 304
+// Not Modified
+func ConditionalJSON(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}, modified time.Time) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if CheckNotModified(w, r, StrongETag(body), modified) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
+
+// ETags buffers the successful GET and HEAD responses without an ETag
+// header and tags them with the hash of their body, answering 304 Not
+// Modified when it matches If-None-Match. Responses larger than 1MB or
+// flushed by the handler are passed through untagged. Handlers writing the
+// encrypted envelope should use ConditionalSuccess, as their bodies never
+// match.
+func ETags() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				h.ServeHTTP(w, r)
+				return
+			}
+			bw := &bufferWriter{ResponseWriter: w, limit: maxTaggedBody}
+
+			// continue
+			h.ServeHTTP(bw, r)
+
+			if bw.through {
+				return
+			}
+			if bw.status == 0 {
+				bw.status = http.StatusOK
+			}
+			// handlers may leave out the body of HEAD responses, whose tag
+			// would then differ from the one of GET
+			tagged := bw.status == http.StatusOK && w.Header().Get("ETag") == "" &&
+				(r.Method == http.MethodGet || bw.body.Len() > 0)
+			if tagged && CheckNotModified(w, r, StrongETag(bw.body.Bytes()), time.Time{}) {
+				return
+			}
+			w.WriteHeader(bw.status)
+			_, _ = w.Write(bw.body.Bytes())
+		})
+	}
+}
+
+// setValidators sets the ETag and Last-Modified headers
+func setValidators(w http.ResponseWriter, etag string, modified time.Time) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// matchETag checks if a list of entity tags, or *, matches etag. Strong
+// comparison fails on weak tags.
+func matchETag(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedSince checks if the resource has not changed since the time of
+// an If-Modified-Since header, at the second precision of the header
+func notModifiedSince(ims string, modified time.Time) bool {
+	if ims == "" || modified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	return err == nil && !modified.Truncate(time.Second).After(t)
+}
+
+// bufferWriter holds back the response until it is tagged, or passes it
+// through once it is too large or flushed
+type bufferWriter struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	limit   int
+	through bool
+}
+
+// WriteHeader records the status code
+func (bw *bufferWriter) WriteHeader(code int) {
+	if bw.status == 0 {
+		bw.status = code
+	}
+}
+
+// Write buffers b until the body is too large to be tagged
+func (bw *bufferWriter) Write(b []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	if !bw.through && bw.body.Len()+len(b) > bw.limit {
+		if err := bw.pass(); err != nil {
+			return 0, err
+		}
+	}
+	if bw.through {
+		return bw.ResponseWriter.Write(b)
+	}
+	return bw.body.Write(b)
+}
+
+// Flush passes the response through, as streamed responses are not tagged
+func (bw *bufferWriter) Flush() {
+	if !bw.through && bw.pass() != nil {
+		return
+	}
+	_ = http.NewResponseController(bw.ResponseWriter).Flush()
+}
+
+// Unwrap allows http.ResponseController to reach the original writer
+func (bw *bufferWriter) Unwrap() http.ResponseWriter {
+	return bw.ResponseWriter
+}
+
+// pass writes the buffered response and passes the rest through
+func (bw *bufferWriter) pass() error {
+	bw.through = true
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	bw.ResponseWriter.WriteHeader(bw.status)
+	_, err := bw.ResponseWriter.Write(bw.body.Bytes())
+	bw.body.Reset()
+	return err
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/database"
+)
+
+// consistencyCookie carries the last write position of a client
+const consistencyCookie = "gf_lsn"
+
+// consistencyWindow is how long a client keeps reading its own writes
+const consistencyWindow = 300
+
+// SessionConsistency lets clients read their own writes from replicas. The
+// write position is kept in the request context and echoed in a cookie, so
+// later requests only read from replicas that have replayed past it. The
+// cookie is signed with the consistencySecret of the database config and
+// expires with the window, so a forged position cannot pin reads to the
+// master.
+func SessionConsistency(meta *Meta) Middleware {
+	key := []byte(meta.Config.Database.ConsistencySecret)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var lsn database.LSN
+			if cookie, err := r.Cookie(consistencyCookie); err == nil {
+				lsn, _ = verifyLSN(key, cookie.Value, time.Now())
+			}
+			session := database.NewSession(lsn)
+
+			ww, rw := wrapWriter(w)
+			rw.beforeHeader = func(code int) {
+				if session.LSN() > lsn {
+					http.SetCookie(w, &http.Cookie{
+						Name:     consistencyCookie,
+						Value:    signLSN(key, session.LSN(), time.Now().Add(consistencyWindow*time.Second)),
+						Path:     "/",
+						MaxAge:   consistencyWindow,
+						HttpOnly: true,
+						Secure:   r.TLS != nil || (meta.IP != nil && meta.IP.Secure(r)),
+						SameSite: http.SameSiteLaxMode,
+					})
+				}
+			}
+
+			// continue
+			h.ServeHTTP(ww, r.WithContext(database.WithSession(r.Context(), session)))
+		})
+	}
+}
+
+// signLSN returns the cookie value of lsn, valid until expires
+func signLSN(key []byte, lsn database.LSN, expires time.Time) string {
+	payload := lsn.String() + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + lsnSignature(key, payload)
+}
+
+// verifyLSN returns the position of a cookie value signed with key, false
+// when it was altered or has expired
+func verifyLSN(key []byte, value string, now time.Time) (database.LSN, bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return 0, false
+	}
+	payload, signature := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(lsnSignature(key, payload))) {
+		return 0, false
+	}
+	text, expires, _ := strings.Cut(payload, ".")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > unix {
+		return 0, false
+	}
+	lsn, err := database.ParseLSN(text)
+	if err != nil {
+		return 0, false
+	}
+	return lsn, true
+}
+
+// lsnSignature returns the hmac of a cookie payload
+func lsnSignature(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
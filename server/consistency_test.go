@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/greatfocus/gf-sframe/database"
+)
+
+func TestVerifyLSN(t *testing.T) {
+	key := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	value := signLSN(key, database.LSN(42), now.Add(time.Minute))
+
+	if lsn, ok := verifyLSN(key, value, now); !ok || lsn != 42 {
+		t.Fatalf("valid cookie read as %s, %v", lsn, ok)
+	}
+	if _, ok := verifyLSN(key, value, now.Add(2*time.Minute)); ok {
+		t.Fatal("expired cookie accepted")
+	}
+	if _, ok := verifyLSN([]byte("other"), value, now); ok {
+		t.Fatal("cookie signed with another key accepted")
+	}
+	forged := "FFFFFFFF/FFFFFFFF" + value[len("0/2A"):]
+	if _, ok := verifyLSN(key, forged, now); ok {
+		t.Fatal("altered position accepted")
+	}
+	if _, ok := verifyLSN(key, "FFFFFFFF/FFFFFFFF", now); ok {
+		t.Fatal("unsigned position accepted")
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Checker reports the health of a dependency
+type Checker func(ctx context.Context) error
+
+// Check is the result of a single checker
+type Check struct {
+	Status   string `json:"status"`
+	Latency  string `json:"latency"`
+	Error    string `json:"error,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// Report is the readiness response
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]Check       `json:"checks"`
+	Info   map[string]interface{} `json:"info,omitempty"`
+}
+
+// Health struct
+type Health struct {
+	mu       sync.RWMutex
+	checkers map[string]Checker
+	optional map[string]bool
+	infos    map[string]func() interface{}
+	draining atomic.Bool
+	timeout  time.Duration
+}
+
+// NewHealth creates health checks that time out after timeout
+func NewHealth(timeout time.Duration) *Health {
+	return &Health{
+		checkers: make(map[string]Checker),
+		optional: make(map[string]bool),
+		infos:    make(map[string]func() interface{}),
+		timeout:  timeout,
+	}
+}
+
+// Register adds a named readiness checker
+func (h *Health) Register(name string, check Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkers[name] = check
+	delete(h.optional, name)
+}
+
+// RegisterOptional adds a named checker that is reported without failing
+// readiness, for dependencies the service can do without
+func (h *Health) RegisterOptional(name string, check Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkers[name] = check
+	h.optional[name] = true
+}
+
+// Info adds a named value to the readiness report, such as a queue depth
+func (h *Health) Info(name string, info func() interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.infos[name] = info
+}
+
+// Drain fails readiness so load balancers stop sending traffic
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// Live handles the liveness probe
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Report{Status: "ok"})
+}
+
+// Ready handles the readiness probe
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	report := h.Check(r.Context())
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// Check runs every checker concurrently
+func (h *Health) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	h.mu.RLock()
+	checkers := make(map[string]Checker, len(h.checkers))
+	for name, check := range h.checkers {
+		checkers[name] = check
+	}
+	optional := make(map[string]bool, len(h.optional))
+	for name := range h.optional {
+		optional[name] = true
+	}
+	report := Report{Status: "ok", Checks: make(map[string]Check, len(checkers))}
+	if len(h.infos) > 0 {
+		report.Info = make(map[string]interface{}, len(h.infos))
+		for name, info := range h.infos {
+			report.Info[name] = info()
+		}
+	}
+	h.mu.RUnlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checkers {
+		wg.Add(1)
+		go func(name string, check Checker) {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			result := Check{Status: "ok", Latency: time.Since(start).String(), Optional: optional[name]}
+			if err != nil {
+				result.Status = "failing"
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			report.Checks[name] = result
+			if err != nil && !result.Optional {
+				report.Status = "failing"
+			}
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	if h.draining.Load() {
+		report.Status = "draining"
+	}
+	return report
+}
+
+// setHealth registers the probes and the frame checkers
+func (m *Meta) setHealth() {
+	if m.Health == nil {
+		return
+	}
+	m.Health.Register("config", func(ctx context.Context) error {
+		if m.Config == nil || m.Config.Impl == "" {
+			return errors.New("config not loaded")
+		}
+		return nil
+	})
+	if m.DB != nil {
+		for _, name := range m.DB.Names() {
+			check := func(ctx context.Context) error {
+				return m.DB.Ping(ctx, name)
+			}
+			if name == "master" {
+				m.Health.Register("database."+name, check)
+				continue
+			}
+			// reads fall back to the master while a replica is down
+			m.Health.RegisterOptional("database."+name, check)
+		}
+	}
+	if m.Cache != nil && m.Config.Cache.Backend != "memory" {
+		// a lookup of a missing key reaches the shared store
+		m.Health.Register("cache", func(ctx context.Context) error {
+			_, _, err := m.Cache.Store().Get(ctx, "health:probe")
+			return err
+		})
+	}
+	if m.Listener != nil {
+		m.Health.Register("database.listener", func(ctx context.Context) error {
+			return m.Listener.Ping()
+		})
+	}
+	if m.Dispatcher != nil {
+		// a busy dispatcher is load, not a reason to leave the rotation
+		m.Health.Info("dispatcher", func() interface{} {
+			return m.dispatcherStatus()
+		})
+	}
+
+	m.Mux.HandleFunc("/healthz", m.Health.Live)
+	m.Mux.HandleFunc("/readyz", m.Health.Ready)
+}
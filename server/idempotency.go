@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/greatfocus/gf-sframe/database"
+	"github.com/greatfocus/gf-sframe/idempotency"
+)
+
+// maxIdempotencyKey is the longest accepted Idempotency-Key header
+const maxIdempotencyKey = 255
+
+// maxIdempotentBody is the largest request body read to fingerprint it
+const maxIdempotentBody = 1 << 20
+
+// Idempotent replays the response of the first request to the retries of
+// an unsafe request carrying the same Idempotency-Key header. Keys are
+// scoped to the user and tenant. A retry gets 409 Conflict while the first
+// request is in flight, and 422 Unprocessable Entity when its method, path,
+// query or body differ. Keys of requests that panic, or fail with a status
+// worth retrying, a 5xx, 429 Too Many Requests or 409 Conflict, are released,
+// and so are those of responses too large to store.
+func Idempotent(meta *Meta) Middleware {
+	cfg := meta.Config.Idempotency
+	methods := make(map[string]bool)
+	for _, method := range cfg.Methods {
+		methods[method] = true
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get(cfg.Header)
+			if header == "" || !methods[r.Method] || meta.Idempotency == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if len(header) > maxIdempotencyKey {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key := meta.idempotencyKey(r, header)
+			record, claimed, err := meta.Idempotency.Claim(r.Context(), key, fingerprint(r, body),
+				cfg.LockTimeout.Duration(), cfg.Expiration.Duration())
+			if err != nil {
+				meta.Logger.Error("idempotency claim failed", "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !claimed {
+				replay(w, r, body, record)
+				return
+			}
+
+			// the response is stored even if the client is gone
+			ctx := context.WithoutCancel(r.Context())
+			defer func() {
+				if p := recover(); p != nil {
+					_ = meta.Idempotency.Release(ctx, key, record.Token)
+					panic(p)
+				}
+			}()
+			cw := &cacheWriter{ResponseWriter: w, limit: maxCachedBody}
+			outer := w.Header().Clone()
+
+			// continue
+			h.ServeHTTP(cw, r)
+
+			// a response too large to store runs again on a retry
+			if retryable(cw.Status()) || cw.overflow {
+				if err := meta.Idempotency.Release(ctx, key, record.Token); err != nil {
+					meta.Logger.Error("idempotency release failed", "error", err)
+				}
+				return
+			}
+			err = meta.Idempotency.Complete(ctx, key, idempotency.Record{
+				Fingerprint: record.Fingerprint,
+				Status:      cw.Status(),
+				Header:      handlerHeader(w.Header(), outer),
+				Body:        cw.body.Bytes(),
+				Token:       record.Token,
+			})
+			if err != nil {
+				meta.Logger.Error("idempotency completion failed", "error", err)
+			}
+		})
+	}
+}
+
+// replay answers a retry with the stored response
+func replay(w http.ResponseWriter, r *http.Request, body []byte, record idempotency.Record) {
+	switch {
+	case record.Fingerprint != fingerprint(r, body):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	case record.InFlight():
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusConflict)
+	default:
+		header := w.Header()
+		for k, v := range record.Header {
+			header[k] = v
+		}
+		header.Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.Status)
+		_, _ = w.Write(record.Body)
+	}
+}
+
+// retryable checks if a response status is transient, so that a retry
+// runs the request again rather than replaying it
+func retryable(status int) bool {
+	return status >= http.StatusInternalServerError ||
+		status == http.StatusTooManyRequests || status == http.StatusConflict
+}
+
+// idempotencyKey scopes the key of a request to its user and tenant
+func (m *Meta) idempotencyKey(r *http.Request, key string) string {
+	var userID int64
+	if m.JWT != nil {
+		userID, _ = m.JWT.getUserID(r)
+	}
+	tenant, _ := database.TenantFromContext(r.Context())
+	return tenant + ":" + strconv.FormatInt(userID, 10) + ":" + key
+}
+
+// fingerprint returns the hash of the method, path, query and body of a
+// request
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gfdispatcher "github.com/greatfocus/gf-dispatcher"
+	"github.com/greatfocus/gf-sframe/database"
+	"github.com/greatfocus/gf-sframe/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// jobStats tracks the jobs submitted through the dispatcher
+type jobStats struct {
+	queued atomic.Int64
+	busy   atomic.Int64
+}
+
+// CronStatus reports the runs of a scheduled job
+type CronStatus struct {
+	Name         string    `json:"name"`
+	Schedule     string    `json:"schedule"`
+	LeaderOnly   bool      `json:"leaderOnly"`
+	Runs         int64     `json:"runs"`
+	Failures     int64     `json:"failures"`
+	Running      bool      `json:"running"`
+	LastRun      time.Time `json:"lastRun,omitempty"`
+	LastDuration string    `json:"lastDuration,omitempty"`
+	LastStatus   string    `json:"lastStatus,omitempty"`
+}
+
+// cronStats tracks the jobs scheduled through the cron table
+type cronStats struct {
+	mu   sync.RWMutex
+	jobs []*CronStatus
+}
+
+// add registers a scheduled job
+func (c *cronStats) add(status *CronStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.jobs = append(c.jobs, status)
+}
+
+// start marks the job as running
+func (c *cronStats) start(status *CronStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status.Running = true
+	status.LastRun = time.Now()
+}
+
+// finish records the outcome of a job run
+func (c *cronStats) finish(status *CronStatus, result string, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status.Running = false
+	status.Runs++
+	if result != "success" {
+		status.Failures++
+	}
+	status.LastStatus = result
+	status.LastDuration = duration.String()
+}
+
+// list returns a copy of the scheduled jobs
+func (c *cronStats) list() []CronStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	jobs := make([]CronStatus, 0, len(c.jobs))
+	for _, status := range c.jobs {
+		jobs = append(jobs, *status)
+	}
+	return jobs
+}
+
+// Dispatch submits a job to the dispatcher and tracks its progress. Jobs
+// added with Dispatcher.AddWorker directly are missing from the dispatcher
+// metrics and status.
+func (m *Meta) Dispatch(job gfdispatcher.Job) {
+	handler := job.Handler
+	job.Handler = func(w http.ResponseWriter, r *http.Request) {
+		m.jobs.queued.Add(-1)
+		m.jobs.busy.Add(1)
+		defer m.jobs.busy.Add(-1)
+
+		// the job span continues the trace of the submitting request
+		ctx := context.Background()
+		if r != nil {
+			ctx = r.Context()
+		}
+		ctx, span := tracing.Start(ctx, "dispatcher.job",
+			trace.WithAttributes(attribute.Int("job.id", job.ID)))
+		defer span.End()
+		if r != nil {
+			r = r.WithContext(ctx)
+		}
+		handler(w, r)
+	}
+	m.jobs.queued.Add(1)
+	m.Dispatcher.AddWorker(job)
+}
+
+// ScheduleJob adds a named job to the cron table and records its runs
+func (m *Meta) ScheduleJob(name, schedule string, fn func(ctx context.Context)) error {
+	return m.scheduleJob(name, schedule, fn, false)
+}
+
+// ScheduleLeaderJob adds a named job that only runs on the elected leader,
+// every instance runs it when leader election is disabled
+func (m *Meta) ScheduleLeaderJob(name, schedule string, fn func(ctx context.Context)) error {
+	return m.scheduleJob(name, schedule, fn, true)
+}
+
+// scheduleJob adds a job to the cron table
+func (m *Meta) scheduleJob(name, schedule string, fn func(ctx context.Context), leaderOnly bool) error {
+	cronStatus := &CronStatus{Name: name, Schedule: schedule, LeaderOnly: leaderOnly}
+	err := m.Cron.AddJob(schedule, func() {
+		if leaderOnly && m.Leader != nil && !m.Leader.IsLeader() {
+			return
+		}
+		start := time.Now()
+		status := "success"
+		m.crons.start(cronStatus)
+		ctx := database.WithCaller(context.Background(), "cron "+name)
+		ctx, span := tracing.Start(ctx, "cron "+name,
+			trace.WithAttributes(attribute.String("cron.job", name)))
+		defer func() {
+			if r := recover(); r != nil {
+				status = "panic"
+				m.Logger.Error("cron job panicked", "job", name, "error", fmt.Sprint(r))
+				span.SetStatus(codes.Error, fmt.Sprint(r))
+			}
+			span.End()
+			m.crons.finish(cronStatus, status, time.Since(start))
+			if m.Metrics != nil {
+				m.Metrics.observeCron(name, status, time.Since(start))
+			}
+		}()
+		fn(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	m.crons.add(cronStatus)
+	return nil
+}
+
+// DispatcherStatus reports the dispatcher load
+type DispatcherStatus struct {
+	Workers int64 `json:"workers"`
+	Queued  int64 `json:"queued"`
+	Busy    int64 `json:"busy"`
+}
+
+// dispatcherStatus returns the current dispatcher load
+func (m *Meta) dispatcherStatus() DispatcherStatus {
+	return DispatcherStatus{
+		Workers: m.Config.Server.Workers,
+		Queued:  m.jobs.queued.Load(),
+		Busy:    m.jobs.busy.Load(),
+	}
+}
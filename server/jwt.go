@@ -2,6 +2,7 @@ package server
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,10 +19,11 @@ type Token struct {
 
 // JWT struct
 type JWT struct {
-	Secret     string
-	Authorized bool
-	Minutes    int64
-	algorithm  gfjwt.Algorithm
+	Secret      string
+	Authorized  bool
+	Minutes     int64
+	TenantClaim string
+	algorithm   gfjwt.Algorithm
 }
 
 // Init method prepare module
@@ -29,12 +31,21 @@ func (j *JWT) Init(config *config.Config) {
 	j.Secret = config.Server.JWT.Secret
 	j.Authorized = config.Server.JWT.Authorized
 	j.Minutes = config.Server.JWT.Minutes
+	j.TenantClaim = config.Tenancy.Claim
 	j.algorithm = gfjwt.HmacSha256(j.Secret)
 }
 
 // CreateToken generates jwt for API login
 func (j *JWT) CreateToken(userID int64, role string, permissions []string) (string, error) {
+	return j.CreateTenantToken(userID, role, permissions, "")
+}
+
+// CreateTenantToken generates jwt for API login carrying the tenant claim
+func (j *JWT) CreateTenantToken(userID int64, role string, permissions []string, tenant string) (string, error) {
 	claims := gfjwt.NewClaim()
+	if tenant != "" && j.TenantClaim != "" {
+		claims.Set(j.TenantClaim, tenant)
+	}
 	claims.Set("authorized", j.Authorized)
 	claims.Set("userID", userID)
 	claims.Set("role", role)
@@ -91,3 +102,66 @@ func (j *JWT) GetToken(r *http.Request) (Token, error) {
 
 	return token, nil
 }
+
+// claims returns the claims of a valid jwt in the request
+func (j *JWT) claims(r *http.Request) (*gfjwt.Claims, bool) {
+	tokenString := j.extractToken(r)
+	if tokenString == "" {
+		return nil, false
+	}
+	claims, err := j.algorithm.DecodeAndValidate(tokenString)
+	if err != nil {
+		return nil, false
+	}
+	return claims, true
+}
+
+// getUserID returns the user id of a valid jwt in the request
+func (j *JWT) getUserID(r *http.Request) (int64, bool) {
+	claims, ok := j.claims(r)
+	if !ok {
+		return 0, false
+	}
+	return userIDClaim(claims)
+}
+
+// getClaim returns a string or numeric claim of a valid jwt in the request
+func (j *JWT) getClaim(r *http.Request, name string) (string, bool) {
+	claims, ok := j.claims(r)
+	if !ok {
+		return "", false
+	}
+	return stringClaim(claims, name)
+}
+
+// userIDClaim returns the user id of the claims
+func userIDClaim(claims *gfjwt.Claims) (int64, bool) {
+	userID, err := claims.Get("userID")
+	if err != nil {
+		return 0, false
+	}
+	switch id := userID.(type) {
+	case float64:
+		return int64(id), true
+	case int64:
+		return id, true
+	}
+	return 0, false
+}
+
+// stringClaim returns a string or numeric claim
+func stringClaim(claims *gfjwt.Claims, name string) (string, bool) {
+	value, err := claims.Get(name)
+	if err != nil {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string:
+		return v, v != ""
+	case float64:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	}
+	return "", false
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric exposed by the frame
+const namespace = "gf"
+
+// Metrics struct
+type Metrics struct {
+	Registry     *prometheus.Registry
+	requests     *prometheus.CounterVec
+	latency      *prometheus.HistogramVec
+	cronRuns     *prometheus.CounterVec
+	cronDuration *prometheus.HistogramVec
+	rateLimited  prometheus.Counter
+}
+
+// NewMetrics creates a registry with the frame collectors
+func NewMetrics(meta *Meta) *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by route, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		cronRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cron",
+			Name:      "runs_total",
+			Help:      "Cron job runs by job and status.",
+		}, []string{"job", "status"}),
+		cronDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "cron",
+			Name:      "run_duration_seconds",
+			Help:      "Cron job run duration by job.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"job"}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "rate_limited_total",
+			Help:      "Requests rejected by the rate limiter.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.latency,
+		m.cronRuns,
+		m.cronDuration,
+		m.rateLimited,
+	)
+	if meta.DB != nil {
+		m.Registry.MustRegister(&dbCollector{meta: meta})
+	}
+	if meta.Cache != nil {
+		m.Registry.MustRegister(
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "cache",
+				Name:      "hits_total",
+				Help:      "Cache lookups that found an item.",
+			}, func() float64 { return float64(meta.Cache.Hits()) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "cache",
+				Name:      "misses_total",
+				Help:      "Cache lookups that did not find an item.",
+			}, func() float64 { return float64(meta.Cache.Misses()) }),
+		)
+	}
+	if meta.Listener != nil {
+		m.Registry.MustRegister(
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "listener",
+				Name:      "dropped_total",
+				Help:      "Database notifications dropped on a full listener queue.",
+			}, func() float64 { return float64(meta.Listener.Dropped()) }),
+		)
+	}
+	// the dispatcher gauges only see the jobs submitted with Meta.Dispatch,
+	// those added with Dispatcher.AddWorker directly are not counted
+	m.Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "dispatcher",
+			Name:      "queue_depth",
+			Help:      "Jobs submitted with Meta.Dispatch waiting for a dispatcher worker.",
+		}, func() float64 { return float64(meta.jobs.queued.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "dispatcher",
+			Name:      "workers_busy",
+			Help:      "Dispatcher workers running a job submitted with Meta.Dispatch.",
+		}, func() float64 { return float64(meta.jobs.busy.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "dispatcher",
+			Name:      "worker_utilization",
+			Help:      "Ratio of busy dispatcher workers.",
+		}, func() float64 {
+			if meta.Config.Server.Workers == 0 {
+				return 0
+			}
+			return float64(meta.jobs.busy.Load()) / float64(meta.Config.Server.Workers)
+		}),
+	)
+	return m
+}
+
+// Register adds a service collector to the registry
+func (m *Metrics) Register(c prometheus.Collector) error {
+	return m.Registry.Register(c)
+}
+
+// Handler serves the registry in Prometheus text exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// observeCron records a cron job run
+func (m *Metrics) observeCron(job, status string, duration time.Duration) {
+	m.cronRuns.WithLabelValues(job, status).Inc()
+	m.cronDuration.WithLabelValues(job).Observe(duration.Seconds())
+}
+
+// RecordMetrics counts requests and their latency by route and status
+func RecordMetrics(meta *Meta) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww, rw := wrapWriter(w)
+
+			// continue
+			h.ServeHTTP(ww, r)
+
+			route := meta.route(r)
+			if route == "" {
+				route = "unmatched"
+			}
+			status := strconv.Itoa(rw.Status())
+			meta.Metrics.requests.WithLabelValues(route, r.Method, status).Inc()
+			meta.Metrics.latency.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+// dbCollector exposes the database/sql pool statistics
+type dbCollector struct {
+	meta *Meta
+}
+
+var (
+	dbOpenDesc = prometheus.NewDesc(namespace+"_db_open_connections",
+		"Established connections both in use and idle.", []string{"db"}, nil)
+	dbInUseDesc = prometheus.NewDesc(namespace+"_db_in_use_connections",
+		"Connections currently in use.", []string{"db"}, nil)
+	dbIdleDesc = prometheus.NewDesc(namespace+"_db_idle_connections",
+		"Idle connections.", []string{"db"}, nil)
+	dbMaxOpenDesc = prometheus.NewDesc(namespace+"_db_max_open_connections",
+		"Maximum number of open connections.", []string{"db"}, nil)
+	dbWaitCountDesc = prometheus.NewDesc(namespace+"_db_wait_count_total",
+		"Connections waited for.", []string{"db"}, nil)
+	dbWaitDurationDesc = prometheus.NewDesc(namespace+"_db_wait_duration_seconds_total",
+		"Time blocked waiting for a connection.", []string{"db"}, nil)
+	dbMaxIdleClosedDesc = prometheus.NewDesc(namespace+"_db_max_idle_closed_total",
+		"Connections closed due to SetMaxIdleConns.", []string{"db"}, nil)
+	dbMaxLifetimeClosedDesc = prometheus.NewDesc(namespace+"_db_max_lifetime_closed_total",
+		"Connections closed due to SetConnMaxLifetime.", []string{"db"}, nil)
+)
+
+// Describe sends the descriptors of the pool metrics
+func (c *dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbOpenDesc
+	ch <- dbInUseDesc
+	ch <- dbIdleDesc
+	ch <- dbMaxOpenDesc
+	ch <- dbWaitCountDesc
+	ch <- dbWaitDurationDesc
+	ch <- dbMaxIdleClosedDesc
+	ch <- dbMaxLifetimeClosedDesc
+}
+
+// Collect reads the pool statistics of every database
+func (c *dbCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, stats := range c.meta.DB.Stats() {
+		ch <- prometheus.MustNewConstMetric(dbOpenDesc, prometheus.GaugeValue, float64(stats.OpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(dbInUseDesc, prometheus.GaugeValue, float64(stats.InUse), name)
+		ch <- prometheus.MustNewConstMetric(dbIdleDesc, prometheus.GaugeValue, float64(stats.Idle), name)
+		ch <- prometheus.MustNewConstMetric(dbMaxOpenDesc, prometheus.GaugeValue, float64(stats.MaxOpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(dbWaitCountDesc, prometheus.CounterValue, float64(stats.WaitCount), name)
+		ch <- prometheus.MustNewConstMetric(dbWaitDurationDesc, prometheus.CounterValue, stats.WaitDuration.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(dbMaxIdleClosedDesc, prometheus.CounterValue, float64(stats.MaxIdleClosed), name)
+		ch <- prometheus.MustNewConstMetric(dbMaxLifetimeClosedDesc, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), name)
+	}
+}
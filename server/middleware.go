@@ -20,7 +20,8 @@ func SetHeaders() Middleware {
 
 			(w).Header().Set("Content-Type", "application/json")
 			(w).Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-			(w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-JWT, Authorization")
+			(w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-JWT, Authorization, If-Match, If-None-Match, If-Modified-Since, If-Unmodified-Since")
+			(w).Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified")
 
 			// continue
 			h.ServeHTTP(w, r)
@@ -57,13 +58,24 @@ func CheckCors(meta *Meta) Middleware {
 }
 
 // CheckLimitsRates handle limits and rates
+//
+// Deprecated: use LimitRates, which counts the rejected requests.
 func CheckLimitsRates() Middleware {
+	return LimitRates(nil)
+}
+
+// LimitRates handle limits and rates, counting the rejected requests in the
+// metrics of meta when they are enabled
+func LimitRates(meta *Meta) Middleware {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 			// limit us requests per second
 			limiter := limiter.GetLimiter(limiter.getIP(r))
 			if !limiter.Allow() {
+				if meta != nil && meta.Metrics != nil {
+					meta.Metrics.rateLimited.Inc()
+				}
 				(w).WriteHeader(http.StatusTooManyRequests)
 				return
 			}
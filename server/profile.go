@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"expvar"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profileGrace is added to the duration of a profile for its write deadline
+const profileGrace = 10 * time.Second
+
+// handleProfiles registers the runtime profiles and variables on the admin
+// mux. The net/http/pprof package is not imported, as it registers its
+// handlers on http.DefaultServeMux, which services may serve on the public
+// port.
+func handleProfiles(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", profileIndex)
+	mux.HandleFunc("/debug/pprof/cmdline", profileCmdline)
+	mux.HandleFunc("/debug/pprof/profile", profileCPU)
+	mux.HandleFunc("/debug/pprof/symbol", profileSymbol)
+	mux.HandleFunc("/debug/pprof/trace", profileTrace)
+	mux.Handle("/debug/vars", expvar.Handler())
+}
+
+// profileIndex lists the profiles, or writes the one named by the path
+func profileIndex(w http.ResponseWriter, r *http.Request) {
+	if name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/"); name != "" {
+		profileNamed(w, r, name)
+		return
+	}
+	profiles := pprof.Profiles()
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+
+	var b bytes.Buffer
+	b.WriteString("<html><head><title>/debug/pprof/</title></head><body><table>\n")
+	for _, p := range profiles {
+		name := html.EscapeString(p.Name())
+		fmt.Fprintf(&b, "<tr><td>%d</td><td><a href=\"%s?debug=1\">%s</a></td></tr>\n", p.Count(), name, name)
+	}
+	b.WriteString("<tr><td></td><td><a href=\"profile\">profile</a></td></tr>\n")
+	b.WriteString("<tr><td></td><td><a href=\"trace?seconds=1\">trace</a></td></tr>\n")
+	b.WriteString("</table></body></html>\n")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(b.Bytes())
+}
+
+// profileNamed writes a profile of the runtime, such as heap or goroutine
+func profileNamed(w http.ResponseWriter, r *http.Request, name string) {
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(w, "unknown profile "+name, http.StatusNotFound)
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if name == "heap" && r.URL.Query().Get("gc") != "" {
+		runtime.GC()
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	_ = p.WriteTo(w, debug)
+}
+
+// profileCmdline writes the command line of the process
+func profileCmdline(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, strings.Join(os.Args, "\x00"))
+}
+
+// profileCPU writes a CPU profile of the given seconds, 30 by default
+func profileCPU(w http.ResponseWriter, r *http.Request) {
+	seconds := profileSeconds(w, r, 30)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "could not enable CPU profiling: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	profileWait(r, seconds)
+	pprof.StopCPUProfile()
+}
+
+// profileTrace writes an execution trace of the given seconds, 1 by default
+func profileTrace(w http.ResponseWriter, r *http.Request) {
+	seconds := profileSeconds(w, r, 1)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "could not enable tracing: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	profileWait(r, seconds)
+	trace.Stop()
+}
+
+// profileSymbol resolves the program counters of the request to function
+// names, for remote symbolization by go tool pprof
+func profileSymbol(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	var b bytes.Buffer
+	b.WriteString("num_symbols: 1\n")
+	var in *bufio.Reader
+	if r.Method == http.MethodPost {
+		in = bufio.NewReader(r.Body)
+	} else {
+		in = bufio.NewReader(strings.NewReader(r.URL.RawQuery))
+	}
+	for {
+		word, err := in.ReadSlice('+')
+		if err == nil {
+			word = word[:len(word)-1]
+		}
+		if pc, _ := strconv.ParseUint(string(word), 0, 64); pc != 0 {
+			if f := runtime.FuncForPC(uintptr(pc)); f != nil {
+				fmt.Fprintf(&b, "%#x %s\n", pc, f.Name())
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(&b, "reading request: %v\n", err)
+			}
+			break
+		}
+	}
+	_, _ = w.Write(b.Bytes())
+}
+
+// profileSeconds reads the seconds parameter and extends the write deadline
+// of the admin server to cover them
+func profileSeconds(w http.ResponseWriter, r *http.Request, fallback int) int {
+	seconds, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+	if err != nil || seconds <= 0 {
+		seconds = fallback
+	}
+	deadline := time.Now().Add(time.Duration(seconds)*time.Second + profileGrace)
+	_ = http.NewResponseController(w).SetWriteDeadline(deadline)
+	return seconds
+}
+
+// profileWait waits for the profile to run, or for the client to leave
+func profileWait(r *http.Request, seconds int) {
+	timer := time.NewTimer(time.Duration(seconds) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
+
+// hideDebug answers 404 to the /debug/ paths, which packages such as
+// expvar, imported by the Prometheus client, register on
+// http.DefaultServeMux
+func hideDebug() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/debug/") {
+				http.NotFound(w, r)
+				return
+			}
+
+			// continue
+			h.ServeHTTP(w, r)
+		})
+	}
+}
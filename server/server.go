@@ -1,34 +1,60 @@
 package server
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	gfbus "github.com/greatfocus/gf-bus"
-	gfcache "github.com/greatfocus/gf-cache"
 	gfcron "github.com/greatfocus/gf-cron"
 	gfdispatcher "github.com/greatfocus/gf-dispatcher"
+	"github.com/greatfocus/gf-sframe/audit"
+	"github.com/greatfocus/gf-sframe/cache"
 	"github.com/greatfocus/gf-sframe/config"
 	"github.com/greatfocus/gf-sframe/crypt"
 	"github.com/greatfocus/gf-sframe/database"
+	"github.com/greatfocus/gf-sframe/idempotency"
+	"github.com/greatfocus/gf-sframe/outbox"
+	"github.com/greatfocus/gf-sframe/tracing"
 )
 
+// tracingShutdownTimeout bounds the flush of pending spans on shutdown
+const tracingShutdownTimeout = 5 * time.Second
+
 // HandlerFunc custom server handler
 type HandlerFunc func(http.ResponseWriter, *http.Request)
 
 // Meta struct
 type Meta struct {
-	Env        string
-	Mux        *http.ServeMux
-	Config     *config.Config
-	DB         *database.Conn
-	Cache      *gfcache.Cache
-	Cron       *gfcron.Cron
-	JWT        *JWT
-	Dispatcher *gfdispatcher.Disp
-	Bus        *gfbus.Bus
+	Env         string
+	Mux         *http.ServeMux
+	Config      *config.Config
+	DB          *database.Conn
+	Cache       *cache.Cache
+	Cron        *gfcron.Cron
+	JWT         *JWT
+	Dispatcher  *gfdispatcher.Disp
+	Bus         gfbus.Bus
+	Logger      *slog.Logger
+	LogLevel    *slog.LevelVar
+	IP          *IPResolver
+	Metrics     *Metrics
+	Tracing     *tracing.Provider
+	Client      *http.Client
+	Health      *Health
+	Outbox      *outbox.Outbox
+	Audit       *audit.Audit
+	Idempotency idempotency.Store
+	Listener    *database.Listener
+	Leader      *database.Elector
+	jobs        jobStats
+	crons       cronStats
+	admin       *http.Server
 }
 
 // Start the server
@@ -36,6 +62,24 @@ func (m *Meta) Start() {
 	// setUploadPath creates an upload path
 	m.setUploadPath()
 
+	// setMetrics exposes the metrics endpoint
+	m.setMetrics()
+
+	// setHealth registers the liveness and readiness probes
+	m.setHealth()
+
+	// setAdmin starts the admin server
+	m.setAdmin()
+
+	// setOutbox schedules the outbox relay
+	m.setOutbox()
+
+	// setCache schedules the removal of expired cache items
+	m.setCache()
+
+	// setIdempotency schedules the removal of expired idempotency keys
+	m.setIdempotency()
+
 	// serve creates server instance
 	m.serve()
 }
@@ -48,6 +92,57 @@ func (m *Meta) setUploadPath() {
 	}
 }
 
+// setMetrics exposes the metrics endpoint
+func (m *Meta) setMetrics() {
+	if m.Metrics != nil {
+		m.Mux.Handle(m.Config.Server.Metrics.Path, m.Metrics.Handler())
+	}
+}
+
+// setOutbox schedules the outbox relay
+func (m *Meta) setOutbox() {
+	if m.Outbox != nil {
+		if err := m.ScheduleJob("outbox-relay", m.Config.Outbox.Schedule, m.Outbox.Relay); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// setCache schedules the removal of expired cache items
+func (m *Meta) setCache() {
+	if m.Cache == nil {
+		return
+	}
+	if purger, ok := m.Cache.Store().(cache.Purger); ok {
+		err := m.ScheduleJob("cache-purge", m.Config.Cache.Schedule, func(ctx context.Context) {
+			if err := purger.Purge(ctx); err != nil {
+				m.Logger.Error("cache purge failed", "error", err)
+			}
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// setIdempotency schedules the removal of expired idempotency keys
+func (m *Meta) setIdempotency() {
+	purger, ok := m.Idempotency.(interface {
+		Purge(ctx context.Context) error
+	})
+	if !ok {
+		return
+	}
+	err := m.ScheduleLeaderJob("idempotency-purge", m.Config.Idempotency.Schedule, func(ctx context.Context) {
+		if err := purger.Purge(ctx); err != nil {
+			m.Logger.Error("idempotency purge failed", "error", err)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 // serve creates server instance
 func (m *Meta) serve() {
 	addr := ":" + m.Config.Server.Port
@@ -56,16 +151,107 @@ func (m *Meta) serve() {
 		ReadTimeout:    time.Duration(m.Config.Server.Timeout) * time.Second,
 		WriteTimeout:   time.Duration(m.Config.Server.Timeout) * time.Second,
 		MaxHeaderBytes: 1 << 20,
-		Handler:        m.Mux,
+		Handler:        m.handler(),
 	}
 
 	// create server connection
-	if m.Config.Env == "prod" {
-		srv.TLSConfig = crypt.TLSServerConfig()
-		log.Println("Listening to port secure HTTPS", addr)
-		log.Fatal(srv.ListenAndServeTLS(os.Args[6], os.Args[7]))
-	} else {
-		log.Println("Listening to port HTTP", addr)
-		log.Fatal(srv.ListenAndServe())
+	go func() {
+		var err error
+		if m.Config.Env == "prod" {
+			srv.TLSConfig = crypt.TLSServerConfig()
+			log.Println("Listening to port secure HTTPS", addr)
+			err = srv.ListenAndServeTLS(os.Args[6], os.Args[7])
+		} else {
+			log.Println("Listening to port HTTP", addr)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// wait for a termination signal
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	m.shutdown(srv)
+}
+
+// shutdown drains traffic and stops the server and background work
+func (m *Meta) shutdown(srv *http.Server) {
+	log.Println("Shutting down server")
+	if m.Health != nil {
+		// give load balancers time to see readiness failing
+		m.Health.Drain()
+		time.Sleep(m.Config.Server.Health.ShutdownDelay.Duration())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(m.Config.Server.Timeout)*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("Server shutdown failed", err)
+	}
+	if m.admin != nil {
+		if err := m.admin.Shutdown(ctx); err != nil {
+			log.Println("Admin server shutdown failed", err)
+		}
+	}
+	if m.Cron != nil {
+		m.Cron.Shutdown()
+	}
+	if m.Leader != nil {
+		if err := m.Leader.Stop(); err != nil {
+			log.Println("Leader election stop failed", err)
+		}
+	}
+	if m.Listener != nil {
+		if err := m.Listener.Close(); err != nil {
+			log.Println("Database listener close failed", err)
+		}
+	}
+	if m.DB != nil {
+		if err := m.DB.Close(); err != nil {
+			log.Println("Database close failed", err)
+		}
+	}
+	if m.Tracing != nil {
+		// the drain may have used up the shutdown deadline
+		tracingCtx, tracingCancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer tracingCancel()
+		if err := m.Tracing.Shutdown(tracingCtx); err != nil {
+			log.Println("Tracing shutdown failed", err)
+		}
+	}
+	log.Println("Server stopped")
+}
+
+// handler wraps the mux with the frame level middleware
+func (m *Meta) handler() http.Handler {
+	var h http.Handler = Use(m.Mux, QueryCaller(m))
+	if m.Mux == http.DefaultServeMux {
+		// profiles and variables are only served on the admin port
+		h = Use(h, hideDebug())
+	}
+	if m.Idempotency != nil {
+		h = Use(h, Idempotent(m))
+	}
+	if m.Config.Tenancy.Enabled {
+		h = Use(h, Tenant(m))
+	}
+	if m.Audit != nil {
+		h = Use(h, AuditContext(m))
+	}
+	if m.Config.Database.SessionConsistency {
+		h = Use(h, SessionConsistency(m))
+	}
+	if m.Metrics != nil {
+		h = Use(h, RecordMetrics(m))
+	}
+	if m.Config.Server.AccessLog.Enabled {
+		h = Use(h, AccessLog(m))
+	}
+	if m.Tracing != nil {
+		h = Use(h, Trace(m))
 	}
+	return h
 }
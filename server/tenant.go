@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/greatfocus/gf-sframe/database"
+)
+
+// Tenant resolves the tenant of each request from the configured sources,
+// in order, and carries it in the request context so that database.Conn
+// scopes the queries of the request to it. The claim of the token is the
+// default source. A tenant named by the header or the subdomain must match
+// the claim of a token carrying one. The header source needs trustHeader,
+// as the header must be set by a gateway in front of the service.
+func Tenant(meta *Meta) Middleware {
+	cfg := meta.Config.Tenancy
+	exempt := map[string]bool{"/healthz": true, "/readyz": true}
+	if meta.Config.Server.Metrics.Enabled {
+		exempt[meta.Config.Server.Metrics.Path] = true
+	}
+	for _, path := range cfg.ExemptPaths {
+		exempt[path] = true
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant, err := meta.resolveTenant(r)
+			if err != nil {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+				return
+			}
+			if tenant == "" {
+				if cfg.Required && !exempt[r.URL.Path] {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "tenant is required"})
+					return
+				}
+				h.ServeHTTP(w, r)
+				return
+			}
+			if !database.ValidTenant(tenant) {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid tenant"})
+				return
+			}
+
+			// continue
+			h.ServeHTTP(w, r.WithContext(database.WithTenant(r.Context(), tenant)))
+		})
+	}
+}
+
+// resolveTenant returns the tenant of the first source naming one, failing
+// when it differs from the claim of the token
+func (m *Meta) resolveTenant(r *http.Request) (string, error) {
+	cfg := m.Config.Tenancy
+	var claimed string
+	if m.JWT != nil {
+		claimed, _ = m.JWT.getClaim(r, cfg.Claim)
+	}
+	for _, source := range cfg.Sources {
+		var tenant string
+		switch source {
+		case "claim":
+			tenant = claimed
+		case "header":
+			tenant = r.Header.Get(cfg.Header)
+		case "subdomain":
+			tenant = subdomain(r.Host, cfg.Domain)
+		}
+		if tenant == "" {
+			continue
+		}
+		if claimed != "" && tenant != claimed {
+			return "", errors.New("tenant does not match the token")
+		}
+		return tenant, nil
+	}
+	return "", nil
+}
+
+// subdomain returns the label in front of domain, or the first label of a
+// host with at least three labels when no domain is configured
+func subdomain(host, domain string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+	if domain != "" {
+		prefix, ok := strings.CutSuffix(host, "."+strings.ToLower(domain))
+		if !ok || strings.Contains(prefix, ".") {
+			return ""
+		}
+		return prefix
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 {
+		return ""
+	}
+	return labels[0]
+}
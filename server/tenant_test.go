@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greatfocus/gf-sframe/config"
+)
+
+func TestTenantMismatch(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.JWT.Secret = "secret"
+	cfg.Server.JWT.Minutes = 5
+	cfg.Tenancy = config.Tenancy{
+		Enabled:     true,
+		Sources:     []string{"header", "claim"},
+		Claim:       "tenant",
+		Header:      "X-Tenant",
+		TrustHeader: true,
+	}
+	jwt := &JWT{}
+	jwt.Init(cfg)
+	meta := &Meta{Config: cfg, JWT: jwt}
+	h := Tenant(meta)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	token, err := jwt.CreateTenantToken(1, "user", nil, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tenant, want := range map[string]int{"acme": http.StatusNoContent, "other": http.StatusForbidden} {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("X-Tenant", tenant)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("tenant %s answered %d, want %d", tenant, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+)
+
+// responseWriter records the status code and size of a response
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	// beforeHeader is called once right before the header is written
+	beforeHeader func(code int)
+}
+
+// WriteHeader records the status code
+func (rw *responseWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		if rw.beforeHeader != nil {
+			rw.beforeHeader(code)
+		}
+		rw.status = code
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Write records the number of bytes written
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the original writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Status returns the recorded status code
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// wrapWriter wraps w in a recording writer while keeping the optional
+// Flusher, Hijacker and Pusher interfaces of the original writer visible
+func wrapWriter(w http.ResponseWriter) (http.ResponseWriter, *responseWriter) {
+	rw := &responseWriter{ResponseWriter: w}
+	f, isFlusher := w.(http.Flusher)
+	h, isHijacker := w.(http.Hijacker)
+	p, isPusher := w.(http.Pusher)
+
+	switch {
+	case isFlusher && isHijacker && isPusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{rw, f, h, p}, rw
+	case isFlusher && isHijacker:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+		}{rw, f, h}, rw
+	case isFlusher && isPusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Pusher
+		}{rw, f, p}, rw
+	case isHijacker && isPusher:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			http.Pusher
+		}{rw, h, p}, rw
+	case isFlusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+		}{rw, f}, rw
+	case isHijacker:
+		return struct {
+			*responseWriter
+			http.Hijacker
+		}{rw, h}, rw
+	case isPusher:
+		return struct {
+			*responseWriter
+			http.Pusher
+		}{rw, p}, rw
+	}
+	return rw, rw
+}